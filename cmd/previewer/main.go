@@ -7,12 +7,14 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"code.cloudfoundry.org/bytefmt"
 	"github.com/pustato/image-previewer/internal/app"
 	"github.com/pustato/image-previewer/internal/cache"
+	"github.com/pustato/image-previewer/internal/cache/filesystem"
 	"github.com/pustato/image-previewer/internal/client"
 	"github.com/pustato/image-previewer/internal/logger"
 	"github.com/pustato/image-previewer/internal/resizer"
@@ -22,13 +24,56 @@ import (
 const (
 	clientTimeout         = 1 * time.Second
 	serverShutdownTimeout = 3 * time.Second
+
+	storageDisk   = "disk"
+	storageMemory = "memory"
+	storageS3     = "s3"
 )
 
 var (
 	port      = flag.String("port", "8000", "service port")
+	adminPort = flag.String("adminPort", "", "port of the admin server listening on localhost, empty disables it")
 	cacheDir  = flag.String("cacheDir", "/tmp/cache", "directory to store cache")
-	cacheSize = flag.String("cacheSize", "100M", "directory to store cache")
+	cacheSize = flag.String("cacheSize", "100M", "total size of cached previews and their metadata, 0 means no limit")
 	logLevel  = flag.String("logLevel", "debug", "logging level (debug|info|warn|error)")
+
+	cacheStorage = flag.String("cacheStorage", storageDisk, "cache storage (disk|memory|s3)")
+	cacheShared  = flag.Bool("cacheShared", false, "share the disk cache with other processes of the host")
+	s3Endpoint   = flag.String("s3Endpoint", "", "endpoint of the s3 storage, e.g. https://s3.amazonaws.com")
+	s3Bucket     = flag.String("s3Bucket", "", "bucket of the s3 storage")
+	s3Region     = flag.String("s3Region", "us-east-1", "region of the s3 storage")
+	s3Prefix     = flag.String("s3Prefix", "previewer/", "prefix of the object keys in the s3 storage, owned by the cache")
+
+	cacheMaxEntries   = flag.Int("cacheMaxEntries", 0, "number of cached previews, 0 means no limit")
+	cacheMaxItemSize  = flag.String("cacheMaxItemSize", "", "largest cached preview, at most cacheSize*cacheLowWatermark")
+	cacheLowWatermark = flag.Float64("cacheLowWatermark", 1, "share of the limits the eviction frees the cache down to")
+	cacheBlockSize    = flag.Bool("cacheBlockSize", false, "account cacheSize by the disk blocks previews take")
+	cacheMinFreeSpace = flag.String("cacheMinFreeSpace", "", "free space the eviction keeps on the cache volume")
+	cacheMemorySize   = flag.String("cacheMemorySize", "", "size of the hottest previews kept in memory")
+	cacheTTL          = flag.Duration("cacheTTL", 0, "lifetime of previews without source max-age or Expires")
+
+	quality    = flag.Int("quality", resizer.DefaultQuality, "default quality of jpeg previews (1-100)")
+	minQuality = flag.Int("minQuality", 1, "minimal quality a request may ask for")
+	maxQuality = flag.Int("maxQuality", 100, "maximal quality a request may ask for")
+
+	cacheMaxAge = flag.Duration("cacheMaxAge", 24*time.Hour, "max-age of the Cache-Control header of previews")
+
+	forwardHeaders = flag.String(
+		"forwardHeaders",
+		strings.Join(app.DefaultForwardHeaders, ","),
+		"comma separated source response headers kept with previews",
+	)
+	cacheKeyHeaders = flag.String("cacheKeyHeaders", "", "comma separated request headers the cache keys depend on")
+	allowHeaders    = flag.String(
+		"allowHeaders",
+		"",
+		"comma separated request headers forwarded to sources, empty means any",
+	)
+	denyHeaders = flag.String(
+		"denyHeaders",
+		strings.Join(server.DefaultDenyHeaders, ","),
+		"comma separated request headers never forwarded to sources",
+	)
 )
 
 func main() {
@@ -50,29 +95,93 @@ func main() {
 		return
 	}
 
-	cacheSizeBytes, err := bytefmt.ToBytes(*cacheSize)
-	if err != nil {
+	cacheLimits := cache.Limits{
+		Entries:         *cacheMaxEntries,
+		LowWatermark:    *cacheLowWatermark,
+		BlockAccounting: *cacheBlockSize,
+		TTL:             *cacheTTL,
+	}
+	if cacheLimits.Size, err = parseSize(*cacheSize); err != nil {
 		logg.Error("invalid cache size: " + err.Error())
 		resultCode = 1
 		return
 	}
+	if cacheLimits.ItemSize, err = parseSize(*cacheMaxItemSize); err != nil {
+		logg.Error("invalid cache max item size: " + err.Error())
+		resultCode = 1
+		return
+	}
+	if cacheLimits.MinFreeSpace, err = parseSize(*cacheMinFreeSpace); err != nil {
+		logg.Error("invalid cache min free space: " + err.Error())
+		resultCode = 1
+		return
+	}
+	if cacheLimits.MemorySize, err = parseSize(*cacheMemorySize); err != nil {
+		logg.Error("invalid cache memory size: " + err.Error())
+		resultCode = 1
+		return
+	}
 
 	clientInstance := client.NewHTTPClient(clientTimeout)
 	resizerInstance := resizer.NewImageResizer()
 
-	appInstance := app.NewResizerApp(clientInstance, resizerInstance)
-	cachedApp, err := cache.NewCacheAppDecorator(appInstance, cacheSizeBytes, *cacheDir)
+	appInstance := app.NewResizerApp(clientInstance, resizerInstance).
+		WithForwardHeaders(splitList(*forwardHeaders))
+	storage, err := newStorage()
+	if err != nil {
+		logg.Error("create cache storage: " + err.Error())
+		resultCode = 1
+		return
+	}
+	newCachedApp := cache.NewCacheAppDecorator
+	if *cacheShared {
+		newCachedApp = cache.NewSharedCacheAppDecorator
+	}
+	cachedApp, err := newCachedApp(appInstance, cacheLimits, storage)
 	if err != nil {
 		logg.Error("create cached app: " + err.Error())
 		resultCode = 1
 		return
 	}
+	cachedApp.WithKeyGenerator(cache.NewHashKeyGenerator(splitList(*cacheKeyHeaders)))
+
+	serverConfig := server.DefaultConfig()
+	serverConfig.DefaultQuality = *quality
+	serverConfig.MinQuality = *minQuality
+	serverConfig.MaxQuality = *maxQuality
+	serverConfig.CacheMaxAge = *cacheMaxAge
+	serverConfig.AllowHeaders = splitList(*allowHeaders)
+	serverConfig.DenyHeaders = splitList(*denyHeaders)
+	if err := serverConfig.Validate(); err != nil {
+		logg.Error("invalid server config: " + err.Error())
+		resultCode = 1
+		return
+	}
+
+	srv := server.NewServer(net.JoinHostPort("0.0.0.0", *port), cachedApp, logg, serverConfig)
 
-	srv := server.NewServer(net.JoinHostPort("0.0.0.0", *port), cachedApp, logg)
+	var adminSrv *server.Server
+	if *adminPort != "" {
+		adminSrv = server.NewAdminServer(net.JoinHostPort("127.0.0.1", *adminPort), cachedApp, logg)
+
+		go func() {
+			logg.Info("starting admin server on " + *adminPort)
+			if err := adminSrv.Start(); err != nil {
+				logg.Error("start admin server: " + err.Error())
+			}
+		}()
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	defer cancel()
 
+	// The background work of the cache stops with the servers, so the leader lock is released on exit.
+	janitorDone := make(chan struct{})
+	go func() {
+		defer close(janitorDone)
+		cachedApp.Run(ctx)
+	}()
+
 	go func() {
 		<-ctx.Done()
 		logg.Info("stopping server...")
@@ -84,6 +193,12 @@ func main() {
 			resultCode = 1
 			logg.Error("stop server:" + err.Error())
 		}
+
+		if adminSrv != nil {
+			if err := adminSrv.Stop(ctx); err != nil {
+				logg.Error("stop admin server: " + err.Error())
+			}
+		}
 	}()
 
 	logg.Info("starting server on " + *port)
@@ -91,4 +206,59 @@ func main() {
 		resultCode = 1
 		logg.Error("start server: " + err.Error())
 	}
+
+	cancel()
+	<-janitorDone
+}
+
+// newStorage creates the cache storage chosen by the flags. The s3 credentials are taken from
+// the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+func newStorage() (filesystem.Filesystem, error) {
+	switch *cacheStorage {
+	case storageDisk:
+		if *cacheShared {
+			return filesystem.NewSharedDiskFilesystem(*cacheDir)
+		}
+
+		return filesystem.NewDiskFilesystem(*cacheDir)
+	case storageMemory:
+		return filesystem.NewMemoryFilesystem(), nil
+	case storageS3:
+		return filesystem.NewS3Filesystem(filesystem.S3Config{
+			Endpoint:  *s3Endpoint,
+			Bucket:    *s3Bucket,
+			Region:    *s3Region,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Prefix:    *s3Prefix,
+		})
+	default:
+		return nil, fmt.Errorf("unknown cache storage %q", *cacheStorage)
+	}
+}
+
+// parseSize parses the byte size flags, 0 and empty mean no limit.
+func parseSize(s string) (uint64, error) {
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+
+	size, err := bytefmt.ToBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("parse size %s: %w", s, err)
+	}
+
+	return size, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
 }
@@ -1,10 +1,16 @@
 package app
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/pustato/image-previewer/internal/client"
 	"github.com/pustato/image-previewer/internal/resizer"
@@ -12,34 +18,187 @@ import (
 
 var ErrRequestError = errors.New("request error")
 
+// upstreamErrorHeaders are the headers of an error response kept for the client.
+var upstreamErrorHeaders = []string{"Cache-Control", "Expires", "Retry-After"}
+
+// DefaultForwardHeaders are the headers of a source response kept with the preview by default.
+var DefaultForwardHeaders = []string{"Cache-Control", "Expires", "Last-Modified", "ETag"}
+
+// UpstreamError is a failed request to the source server. StatusCode is 0 when no response was received,
+// Err holds the reason then.
+type UpstreamError struct {
+	StatusCode int
+	Header     http.Header
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.StatusCode == 0 {
+		return "upstream request: " + e.Err.Error()
+	}
+
+	return fmt.Sprintf("upstream responded %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UpstreamError) Is(target error) bool {
+	return target == ErrRequestError
+}
+
 type App interface {
-	GetAndResize(ctx context.Context, url string, w, h int, headers http.Header) ([]byte, error)
+	GetAndResize(ctx context.Context, url string, opts resizer.Options, headers http.Header) (*Image, error)
+}
+
+// Image is a rendered preview.
+type Image struct {
+	// Body reads the content of the preview, the receiver of the image closes it.
+	Body io.ReadSeekCloser
+	// ETag is a strong validator derived from the content.
+	ETag string
+	// ModTime is the time the preview was rendered at.
+	ModTime time.Time
+	// Header holds the forwarded headers of the source response.
+	Header http.Header
+	// Vary lists the request headers the source response depends on.
+	Vary []string
+}
+
+func NewImage(content []byte, modTime time.Time) *Image {
+	hash := sha256.Sum256(content)
+
+	return &Image{
+		Body:    NewContent(content),
+		ETag:    `"` + hex.EncodeToString(hash[:]) + `"`,
+		ModTime: modTime,
+	}
+}
+
+// Bytes returns the content of the image. The in-memory content is returned as is, other bodies
+// are read from the start.
+func (i *Image) Bytes() ([]byte, error) {
+	if c, ok := i.Body.(*Content); ok {
+		return c.Bytes(), nil
+	}
+
+	if _, err := i.Body.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("image read body: %w", err)
+	}
+
+	content, err := io.ReadAll(i.Body)
+	if err != nil {
+		return nil, fmt.Errorf("image read body: %w", err)
+	}
+
+	return content, nil
+}
+
+// Clone returns a copy of the image. The copy of an image held in memory reads the content on its own,
+// so the copies may be served concurrently; other bodies are shared.
+func (i *Image) Clone() *Image {
+	clone := *i
+	if c, ok := i.Body.(*Content); ok {
+		clone.Body = NewContent(c.Bytes())
+	}
+
+	return &clone
+}
+
+// Content is the body of an image held in memory.
+type Content struct {
+	*bytes.Reader
+	content []byte
+}
+
+func NewContent(content []byte) *Content {
+	return &Content{
+		Reader:  bytes.NewReader(content),
+		content: content,
+	}
+}
+
+// Bytes returns the whole content regardless of the read position.
+func (c *Content) Bytes() []byte {
+	return c.content
+}
+
+func (c *Content) Close() error {
+	return nil
 }
 
 func NewResizerApp(c client.Client, r resizer.Resizer) *ResizerApp {
-	return &ResizerApp{c, r}
+	return &ResizerApp{c, r, DefaultForwardHeaders}
 }
 
 type ResizerApp struct {
-	client  client.Client
-	resizer resizer.Resizer
+	client         client.Client
+	resizer        resizer.Resizer
+	forwardHeaders []string
 }
 
-func (a *ResizerApp) GetAndResize(ctx context.Context, url string, w, h int, headers http.Header) ([]byte, error) {
+func (a *ResizerApp) WithForwardHeaders(headers []string) *ResizerApp {
+	a.forwardHeaders = headers
+
+	return a
+}
+
+func (a *ResizerApp) GetAndResize(
+	ctx context.Context,
+	url string,
+	opts resizer.Options,
+	headers http.Header,
+) (*Image, error) {
 	rsp, err := a.client.GetWithHeaders(ctx, url, headers)
 	if err != nil {
-		return nil, fmt.Errorf("ResizerApp get %s: %w", url, err)
+		return nil, fmt.Errorf("ResizerApp get %s: %w", url, &UpstreamError{Err: err})
 	}
 	defer rsp.Body.Close()
 
 	if rsp.StatusCode != http.StatusOK {
-		return nil, ErrRequestError
+		upstreamErr := &UpstreamError{
+			StatusCode: rsp.StatusCode,
+			Header:     selectHeaders(rsp.Header, upstreamErrorHeaders),
+		}
+
+		return nil, fmt.Errorf("ResizerApp get %s: %w", url, upstreamErr)
 	}
 
-	result, err := a.resizer.Resize(rsp.Body, w, h)
+	result, err := a.resizer.Resize(rsp.Body, opts)
 	if err != nil {
 		return nil, fmt.Errorf("ResizerApp resize: %w", err)
 	}
 
-	return result, err
+	img := NewImage(result, time.Now())
+	img.Header = selectHeaders(rsp.Header, a.forwardHeaders)
+	img.Vary = parseVary(rsp.Header)
+
+	return img, nil
+}
+
+func selectHeaders(header http.Header, keys []string) http.Header {
+	selected := http.Header{}
+
+	for _, key := range keys {
+		if values := header.Values(key); len(values) > 0 {
+			selected[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+
+	return selected
+}
+
+func parseVary(header http.Header) []string {
+	var vary []string
+
+	for _, v := range header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				vary = append(vary, h)
+			}
+		}
+	}
+
+	return vary
 }
@@ -3,10 +3,14 @@ package app
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	mockclient "github.com/pustato/image-previewer/internal/client/mocks"
+	"github.com/pustato/image-previewer/internal/resizer"
 	mockresizer "github.com/pustato/image-previewer/internal/resizer/mocks"
 	"github.com/stretchr/testify/require"
 )
@@ -15,6 +19,11 @@ var (
 	ctx     = context.Background()
 	url     = "http://test.url/"
 	headers = http.Header{}
+	opts    = resizer.Options{
+		Operation: resizer.OperationFill,
+		Width:     100,
+		Height:    100,
+	}
 )
 
 type bodyStub struct{}
@@ -31,7 +40,14 @@ func TestResizerApp_GetAndResize_Success(t *testing.T) {
 	body := &bodyStub{}
 	rsp := &http.Response{
 		StatusCode: http.StatusOK,
-		Body:       body,
+		Header: http.Header{
+			"Cache-Control": {"max-age=3600"},
+			"Etag":          {`"source"`},
+			"Set-Cookie":    {"session=secret"},
+			"Content-Type":  {"image/jpeg"},
+			"Vary":          {"Accept-Encoding, Accept-Language", "Cookie"},
+		},
+		Body: body,
 	}
 	expectedResult := []byte("expected result")
 
@@ -43,15 +59,113 @@ func TestResizerApp_GetAndResize_Success(t *testing.T) {
 
 	resizer := &mockresizer.Resizer{}
 	resizer.
-		On("Resize", body, 100, 100).
+		On("Resize", body, opts).
 		Once().
 		Return(expectedResult, nil)
 
 	app := NewResizerApp(client, resizer)
 
-	res, err := app.GetAndResize(ctx, url, 100, 100, headers)
+	res, err := app.GetAndResize(ctx, url, opts, headers)
+	require.NoError(t, err)
+	content, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.EqualValues(t, expectedResult, content)
+	require.Equal(t, NewImage(expectedResult, time.Time{}).ETag, res.ETag)
+	require.False(t, res.ModTime.IsZero())
+	require.Equal(t, http.Header{
+		"Cache-Control": {"max-age=3600"},
+		"Etag":          {`"source"`},
+	}, res.Header)
+	require.Equal(t, []string{"Accept-Encoding", "Accept-Language", "Cookie"}, res.Vary)
+}
+
+func TestResizerApp_GetAndResize_ForwardHeaders(t *testing.T) {
+	body := &bodyStub{}
+	rsp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": {"max-age=3600"},
+			"X-Custom":      {"value1", "value2"},
+		},
+		Body: body,
+	}
+
+	client := &mockclient.Client{}
+	client.
+		On("GetWithHeaders", ctx, url, headers).
+		Once().
+		Return(rsp, nil)
+
+	resizer := &mockresizer.Resizer{}
+	resizer.
+		On("Resize", body, opts).
+		Once().
+		Return([]byte("expected result"), nil)
+
+	app := NewResizerApp(client, resizer).WithForwardHeaders([]string{"x-custom", "expires"})
+
+	res, err := app.GetAndResize(ctx, url, opts, headers)
+	require.NoError(t, err)
+	require.Equal(t, http.Header{"X-Custom": {"value1", "value2"}}, res.Header)
+}
+
+func TestNewImage(t *testing.T) {
+	modTime := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	img := NewImage([]byte("content"), modTime)
+	require.Equal(t, modTime, img.ModTime)
+	require.Regexp(t, `^"[0-9a-f]{64}"$`, img.ETag)
+
+	require.Equal(t, img.ETag, NewImage([]byte("content"), time.Now()).ETag)
+	require.NotEqual(t, img.ETag, NewImage([]byte("other content"), modTime).ETag)
+}
+
+// fileBody is a body streamed from elsewhere.
+type fileBody struct {
+	*strings.Reader
+}
+
+func (fileBody) Close() error {
+	return nil
+}
+
+func TestImage_Bytes(t *testing.T) {
+	img := NewImage([]byte("content"), time.Now())
+	_, err := img.Body.Seek(3, io.SeekStart)
+	require.NoError(t, err)
+
+	content, err := img.Bytes()
 	require.NoError(t, err)
-	require.EqualValues(t, expectedResult, res)
+	require.Equal(t, []byte("content"), content)
+
+	img.Body = fileBody{strings.NewReader("streamed")}
+	_, err = img.Body.Seek(3, io.SeekStart)
+	require.NoError(t, err)
+
+	content, err = img.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, []byte("streamed"), content)
+}
+
+func TestImage_Clone(t *testing.T) {
+	img := NewImage([]byte("content"), time.Now())
+	img.Header = http.Header{"Cache-Control": {"max-age=60"}}
+
+	clone := img.Clone()
+	require.Equal(t, img.ETag, clone.ETag)
+	require.Equal(t, img.Header, clone.Header)
+	require.NotSame(t, img.Body, clone.Body)
+
+	content, err := io.ReadAll(img.Body)
+	require.NoError(t, err)
+	require.Equal(t, []byte("content"), content)
+
+	content, err = io.ReadAll(clone.Body)
+	require.NoError(t, err)
+	require.Equal(t, []byte("content"), content, "the clone reads on its own")
+
+	img.Body = fileBody{strings.NewReader("streamed")}
+	require.Equal(t, img.Body, img.Clone().Body)
 }
 
 func TestResizerApp_GetAndResize_Errors(t *testing.T) {
@@ -71,10 +185,15 @@ func TestResizerApp_GetAndResize_Errors(t *testing.T) {
 		resizer := &mockresizer.Resizer{}
 
 		app := NewResizerApp(client, resizer)
-		res, err := app.GetAndResize(ctx, url, 100, 100, headers)
+		res, err := app.GetAndResize(ctx, url, opts, headers)
 		require.Nil(t, res)
 		require.Error(t, err)
 		require.ErrorIs(t, err, expectedError)
+		require.ErrorIs(t, err, ErrRequestError)
+
+		var upstreamErr *UpstreamError
+		require.ErrorAs(t, err, &upstreamErr)
+		require.Zero(t, upstreamErr.StatusCode)
 	})
 
 	t.Run("client GetWithHeaders request error", func(t *testing.T) {
@@ -82,7 +201,11 @@ func TestResizerApp_GetAndResize_Errors(t *testing.T) {
 
 		rsp := &http.Response{
 			StatusCode: http.StatusNotFound,
-			Body:       &bodyStub{},
+			Header: http.Header{
+				"Cache-Control": {"max-age=60"},
+				"Set-Cookie":    {"session=secret"},
+			},
+			Body: &bodyStub{},
 		}
 
 		client := &mockclient.Client{}
@@ -93,10 +216,15 @@ func TestResizerApp_GetAndResize_Errors(t *testing.T) {
 
 		resizer := &mockresizer.Resizer{}
 		app := NewResizerApp(client, resizer)
-		res, err := app.GetAndResize(ctx, url, 100, 100, headers)
+		res, err := app.GetAndResize(ctx, url, opts, headers)
 		require.Nil(t, res)
 		require.Error(t, err)
 		require.ErrorIs(t, err, ErrRequestError)
+
+		var upstreamErr *UpstreamError
+		require.ErrorAs(t, err, &upstreamErr)
+		require.Equal(t, http.StatusNotFound, upstreamErr.StatusCode)
+		require.Equal(t, http.Header{"Cache-Control": {"max-age=60"}}, upstreamErr.Header)
 	})
 
 	t.Run("resizer error", func(t *testing.T) {
@@ -117,12 +245,12 @@ func TestResizerApp_GetAndResize_Errors(t *testing.T) {
 
 		resizer := &mockresizer.Resizer{}
 		resizer.
-			On("Resize", body, 100, 100).
+			On("Resize", body, opts).
 			Once().
 			Return(nil, expectedError)
 
 		app := NewResizerApp(client, resizer)
-		res, err := app.GetAndResize(ctx, url, 100, 100, headers)
+		res, err := app.GetAndResize(ctx, url, opts, headers)
 		require.Nil(t, res)
 		require.Error(t, err)
 		require.ErrorIs(t, err, expectedError)
@@ -6,7 +6,11 @@ import (
 	context "context"
 	http "net/http"
 
+	app "github.com/pustato/image-previewer/internal/app"
+
 	mock "github.com/stretchr/testify/mock"
+
+	resizer "github.com/pustato/image-previewer/internal/resizer"
 )
 
 // App is an autogenerated mock type for the App type
@@ -14,22 +18,22 @@ type App struct {
 	mock.Mock
 }
 
-// GetAndResize provides a mock function with given fields: ctx, url, w, h, headers
-func (_m *App) GetAndResize(ctx context.Context, url string, w int, h int, headers http.Header) ([]byte, error) {
-	ret := _m.Called(ctx, url, w, h, headers)
+// GetAndResize provides a mock function with given fields: ctx, url, opts, headers
+func (_m *App) GetAndResize(ctx context.Context, url string, opts resizer.Options, headers http.Header) (*app.Image, error) {
+	ret := _m.Called(ctx, url, opts, headers)
 
-	var r0 []byte
-	if rf, ok := ret.Get(0).(func(context.Context, string, int, int, http.Header) []byte); ok {
-		r0 = rf(ctx, url, w, h, headers)
+	var r0 *app.Image
+	if rf, ok := ret.Get(0).(func(context.Context, string, resizer.Options, http.Header) *app.Image); ok {
+		r0 = rf(ctx, url, opts, headers)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]byte)
+			r0 = ret.Get(0).(*app.Image)
 		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, string, int, int, http.Header) error); ok {
-		r1 = rf(ctx, url, w, h, headers)
+	if rf, ok := ret.Get(1).(func(context.Context, string, resizer.Options, http.Header) error); ok {
+		r1 = rf(ctx, url, opts, headers)
 	} else {
 		r1 = ret.Error(1)
 	}
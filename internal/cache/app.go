@@ -2,83 +2,347 @@ package cache
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/pustato/image-previewer/internal/app"
 	"github.com/pustato/image-previewer/internal/cache/filesystem"
 	"github.com/pustato/image-previewer/internal/cache/lru"
+	"github.com/pustato/image-previewer/internal/resizer"
 )
 
 var _ app.App = (*AppCacheDecorator)(nil)
 
 type AppCacheDecorator struct {
-	app   app.App
-	cache lru.Cache
-	fs    filesystem.Filesystem
+	// stats goes first to keep its counters aligned for the atomic operations.
+	stats       Stats
+	app         app.App
+	cache       lru.Cache
+	fs          filesystem.Filesystem
+	keys        KeyGenerator
+	flights     *flightGroup
+	maxItemSize uint64
+	// blockSize is set when the previews are accounted by the blocks they take on the cache volume.
+	blockSize uint64
+	// volume is set when the free space on the cache volume is guarded.
+	volume       filesystem.Volume
+	minFreeSpace uint64
+	// hot is set when the in-memory hot tier is enabled.
+	hot *hotTier
+	// shared is set when other processes share the storage.
+	shared *sharedStorage
+	// ttl is the lifetime of the previews the source does not limit, 0 means until evicted.
+	ttl             time.Duration
+	janitorInterval time.Duration
 }
 
-func NewCacheAppDecorator(app app.App, limit uint64, cachePath string) (*AppCacheDecorator, error) {
-	fs, err := filesystem.NewDiskFilesystem(cachePath)
-	if err != nil {
+func NewCacheAppDecorator(app app.App, limits Limits, fs filesystem.Filesystem) (*AppCacheDecorator, error) {
+	return newCacheAppDecorator(app, limits, fs, nil)
+}
+
+func newCacheAppDecorator(
+	app app.App,
+	limits Limits,
+	fs filesystem.Filesystem,
+	shared *sharedStorage,
+) (*AppCacheDecorator, error) {
+	if err := limits.Validate(); err != nil {
 		return nil, fmt.Errorf("new cached app: %w", err)
 	}
 
-	return &AppCacheDecorator{
-		app: app,
-		cache: lru.NewCache(limit, func(item *lru.Item) {
-			_ = fs.RemoveFile(item.FileName)
-		}),
-		fs: fs,
-	}, nil
+	decorator := &AppCacheDecorator{
+		app:             app,
+		fs:              fs,
+		keys:            NewHashKeyGenerator(nil),
+		flights:         newFlightGroup(),
+		maxItemSize:     limits.maxItemSize(),
+		shared:          shared,
+		ttl:             limits.TTL,
+		janitorInterval: defaultJanitorInterval,
+	}
+
+	if limits.MemorySize > 0 {
+		decorator.hot = newHotTier(limits.MemorySize)
+	}
+
+	blockSize := uint64(0)
+	if limits.BlockAccounting || limits.MinFreeSpace > 0 {
+		volume, ok := fs.(filesystem.Volume)
+		if !ok {
+			return nil, fmt.Errorf("new cached app: %w", filesystem.ErrVolumeStatNotSupported)
+		}
+
+		stat, err := volume.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("new cached app: %w", err)
+		}
+
+		if limits.BlockAccounting {
+			blockSize = stat.BlockSize
+		}
+
+		if limits.MinFreeSpace > 0 {
+			decorator.volume = volume
+			decorator.minFreeSpace = limits.MinFreeSpace
+		}
+	}
+
+	decorator.blockSize = blockSize
+	decorator.cache = limits.newIndex(blockSize, decorator.evict)
+	if shared != nil {
+		shared.limits, shared.blockSize = limits, blockSize
+	}
+
+	if err := decorator.restore(); err != nil {
+		return nil, fmt.Errorf("new cached app: %w", err)
+	}
+	decorator.ensureFreeSpace(0)
+
+	return decorator, nil
+}
+
+func (a *AppCacheDecorator) WithKeyGenerator(keys KeyGenerator) *AppCacheDecorator {
+	a.keys = keys
+
+	return a
 }
 
 func (a *AppCacheDecorator) GetAndResize(
 	ctx context.Context,
 	url string,
-	w, h int,
+	opts resizer.Options,
 	headers http.Header,
-) ([]byte, error) {
-	key := a.generateKey(url, w, h)
+) (*app.Image, error) {
+	key := a.keys.Key(url, opts, headers)
 
-	item, found := a.cache.Get(key)
+	item, found := a.lookup(key)
 	if found {
-		content, err := a.fs.ReadFile(item.FileName)
-		if err != nil {
+		body, err := a.open(key, item)
+		if err == nil {
+			return &app.Image{
+				Body:    body,
+				ETag:    item.ETag,
+				ModTime: item.ModTime,
+				Header:  remainingHeader(item.Header, item.ExpiresAt, time.Now()),
+			}, nil
+		}
+
+		// Another process sharing the storage may have evicted the preview, or the removal of the files
+		// of an evicted preview may have finished after the preview was cached again.
+		if !errors.Is(err, filesystem.ErrFileNotExists) {
 			return nil, fmt.Errorf("cached app hit: %w", err)
 		}
+		a.cache.Remove(key)
+	}
+
+	// The hot tier never keeps the previews the storage misses, the hot tier miss of a preview
+	// evicted by another process is counted already.
+	if a.hot != nil && !found {
+		atomic.AddUint64(&a.stats.Memory.Misses, 1)
+	}
+	atomic.AddUint64(&a.stats.Storage.Misses, 1)
+
+	img, err := a.flights.do(ctx, key, func(ctx context.Context) (*app.Image, error) {
+		return a.fetch(ctx, key, url, opts, headers)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The coalesced callers share the image, each of them reads its own body.
+	return img.Clone(), nil
+}
+
+// lookup returns the item of the cached preview. In the shared mode the previews cached by other
+// processes are looked up in the storage.
+func (a *AppCacheDecorator) lookup(key string) (*lru.Item, bool) {
+	if item, ok := a.cache.Get(key); ok {
+		return item, true
+	}
+
+	if a.shared == nil {
+		return nil, false
+	}
+
+	return a.adopt(key)
+}
+
+// open returns the body of the cached preview from the hot tier, or from the storage. The previews
+// fitting the hot tier are read whole to be promoted to it, the others are streamed from the storage.
+func (a *AppCacheDecorator) open(key string, item *lru.Item) (io.ReadSeekCloser, error) {
+	if a.shared != nil {
+		a.shared.touch(item.FileName)
+	}
+
+	if a.hot != nil {
+		if content, ok := a.hot.get(key, item.ETag); ok {
+			atomic.AddUint64(&a.stats.Memory.Hits, 1)
+
+			return app.NewContent(content), nil
+		}
 
-		return content, nil
+		atomic.AddUint64(&a.stats.Memory.Misses, 1)
 	}
 
-	content, err := a.app.GetAndResize(ctx, url, w, h, headers)
+	if a.hot == nil || item.Size > a.hot.limit {
+		body, err := a.fs.Open(item.FileName)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddUint64(&a.stats.Storage.Hits, 1)
+
+		return body, nil
+	}
+
+	content, err := a.fs.ReadFile(item.FileName)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&a.stats.Storage.Hits, 1)
+	a.hot.set(key, item.ETag, content)
+
+	return app.NewContent(content), nil
+}
+
+func (a *AppCacheDecorator) fetch(
+	ctx context.Context,
+	key string,
+	url string,
+	opts resizer.Options,
+	headers http.Header,
+) (*app.Image, error) {
+	img, err := a.app.GetAndResize(ctx, url, opts, headers)
 	if err != nil {
 		return nil, fmt.Errorf("cached app proxy call: %w", err)
 	}
 
-	item = &lru.Item{
-		FileName: key + ".jpg",
-		Size:     uint64(len(content)),
+	// The image is held in memory to be saved and cloned for the coalesced callers.
+	content, err := img.Bytes()
+	_ = img.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cached app proxy call: %w", err)
 	}
+	img.Body = app.NewContent(content)
 
-	if err := a.fs.WriteFile(item.FileName, content); err != nil {
-		return nil, fmt.Errorf("cached app save content: %w", err)
+	if !a.cacheable(img) {
+		return img, nil
+	}
+
+	now := time.Now()
+	expires, ok := expiresAt(img.Header, a.ttl, now)
+	if !ok {
+		return img, nil
+	}
+
+	item := &lru.Item{
+		FileName:    key + "." + opts.Format.Extension(),
+		Size:        uint64(len(content)),
+		URL:         url,
+		Operation:   string(opts.Operation),
+		Width:       opts.Width,
+		Height:      opts.Height,
+		ContentType: opts.Format.ContentType(),
+		ETag:        img.ETag,
+		ModTime:     img.ModTime,
+		CreatedAt:   now,
+		Header:      img.Header,
+		ExpiresAt:   expires,
+	}
+
+	metadata, err := encodeMetadata(item)
+	if err != nil {
+		return nil, fmt.Errorf("cached app save: %w", err)
+	}
+
+	if a.oversized(item) {
+		atomic.AddUint64(&a.stats.Oversized, 1)
+
+		return img, nil
+	}
+
+	if !a.ensureFreeSpace(a.diskSize(item)) {
+		return img, nil
+	}
+
+	if err := a.save(item, content, metadata); err != nil {
+		return nil, err
 	}
 	a.cache.Set(key, item)
 
-	return content, nil
+	return img, nil
+}
+
+// oversized tells whether the preview is larger than the largest one to cache.
+func (a *AppCacheDecorator) oversized(item *lru.Item) bool {
+	return a.maxItemSize > 0 && a.diskSize(item) > a.maxItemSize
 }
 
-func (a *AppCacheDecorator) generateKey(url string, w, h int) string {
-	hash := sha256.New()
+// diskSize returns the size the preview and its metadata are accounted by, the index charges the same.
+func (a *AppCacheDecorator) diskSize(item *lru.Item) uint64 {
+	return item.DiskSize(a.blockSize)
+}
+
+func (a *AppCacheDecorator) save(item *lru.Item, content, metadata []byte) error {
+	unlock, err := a.lockIndex(false)
+	if err != nil {
+		return fmt.Errorf("cached app save: %w", err)
+	}
+	defer unlock()
+
+	if err := a.fs.WriteFile(item.FileName, content); err != nil {
+		return fmt.Errorf("cached app save content: %w", err)
+	}
+	if err := a.fs.WriteFile(metadataFileName(item.FileName), metadata); err != nil {
+		_ = a.fs.RemoveFile(item.FileName)
+
+		return fmt.Errorf("cached app save metadata: %w", err)
+	}
+
+	return nil
+}
+
+// ensureFreeSpace evicts the previews until the cache volume keeps the min free space after a write
+// of size. It returns false when the space can not be freed.
+func (a *AppCacheDecorator) ensureFreeSpace(size uint64) bool {
+	if a.volume == nil {
+		return true
+	}
 
-	io.WriteString(hash, url)
-	io.WriteString(hash, strconv.Itoa(w))
-	io.WriteString(hash, strconv.Itoa(h))
+	for {
+		stat, err := a.volume.Stat()
+		if err != nil {
+			return false
+		}
+
+		if stat.Free >= a.minFreeSpace+size {
+			return true
+		}
+
+		// The leader frees the space of the shared storage.
+		if a.shared != nil || !a.cache.RemoveOldest() {
+			return false
+		}
+	}
+}
+
+// cacheable tells whether the source response is the same for every request sharing the key.
+// The source Vary may only list the headers the keys are derived from.
+func (a *AppCacheDecorator) cacheable(img *app.Image) bool {
+	for _, header := range img.Vary {
+		// The client negotiates the encoding itself and always gets the decoded body.
+		if strings.EqualFold(header, "Accept-Encoding") {
+			continue
+		}
+
+		if header == "*" || !a.keys.Varies(header) {
+			return false
+		}
+	}
 
-	return hex.EncodeToString(hash.Sum(nil))
+	return true
 }
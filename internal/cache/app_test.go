@@ -2,9 +2,14 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/pustato/image-previewer/internal/app"
 	mockapp "github.com/pustato/image-previewer/internal/app/mocks"
@@ -12,34 +17,79 @@ import (
 	mockfilesystem "github.com/pustato/image-previewer/internal/cache/filesystem/mocks"
 	"github.com/pustato/image-previewer/internal/cache/lru"
 	mocklru "github.com/pustato/image-previewer/internal/cache/lru/mocks"
+	"github.com/pustato/image-previewer/internal/resizer"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
 var (
 	ctx          = context.Background()
+	anyContext   = mock.MatchedBy(func(_ context.Context) bool { return true })
 	anyCacheKey  = mock.MatchedBy(func(_ string) bool { return true })
 	anyFileName  = mock.MatchedBy(func(_ string) bool { return true })
+	anyMetadata  = mock.MatchedBy(func(name string) bool { return strings.HasSuffix(name, ".json") })
+	anyContent   = mock.AnythingOfType("[]uint8")
 	anyCacheItem = mock.MatchedBy(func(_ *lru.Item) bool { return true })
 	headers      = http.Header{}
 	url          = "http://google.com/"
+	opts         = resizer.Options{
+		Operation: resizer.OperationFill,
+		Width:     100,
+		Height:    100,
+		EncodeOptions: resizer.EncodeOptions{
+			Format:  resizer.FormatJPEG,
+			Quality: 80,
+		},
+	}
 )
 
 func createApp(app app.App, cache lru.Cache, fs filesystem.Filesystem) *AppCacheDecorator {
 	return &AppCacheDecorator{
-		app:   app,
-		cache: cache,
-		fs:    fs,
+		app:         app,
+		cache:       cache,
+		fs:          fs,
+		keys:        NewHashKeyGenerator([]string{"Accept-Language"}),
+		flights:     newFlightGroup(),
+		maxItemSize: 1024,
 	}
 }
 
+// imageContent returns the content of the image held in memory.
+// saveItem writes the preview and its metadata as a miss does.
+func saveItem(t *testing.T, a *AppCacheDecorator, item *lru.Item, content []byte) {
+	t.Helper()
+
+	metadata, err := encodeMetadata(item)
+	require.NoError(t, err)
+	require.NoError(t, a.save(item, content, metadata))
+}
+
+func imageContent(img *app.Image) []byte {
+	return img.Body.(*app.Content).Bytes()
+}
+
+func readBody(t *testing.T, img *app.Image) []byte {
+	t.Helper()
+
+	content, err := io.ReadAll(img.Body)
+	require.NoError(t, err)
+	require.NoError(t, img.Body.Close())
+
+	return content
+}
+
 func TestAppCacheDecorator_GetAndResize_Success(t *testing.T) {
 	t.Run("hit cache", func(t *testing.T) {
 		fileName := "some_file_name"
+		result := []byte("success result")
+		expected := app.NewImage(result, time.Now())
+		expected.Header = http.Header{"Cache-Control": {"max-age=60"}}
 		item := &lru.Item{
 			FileName: fileName,
+			ETag:     expected.ETag,
+			ModTime:  expected.ModTime,
+			Header:   expected.Header,
 		}
-		result := []byte("success result")
 
 		cache := &mocklru.Cache{}
 		cache.
@@ -49,22 +99,24 @@ func TestAppCacheDecorator_GetAndResize_Success(t *testing.T) {
 
 		fs := &mockfilesystem.Filesystem{}
 		fs.
-			On("ReadFile", fileName).
+			On("Open", fileName).
 			Once().
-			Return(result, nil)
+			Return(app.NewContent(result), nil)
 
 		unit := createApp(&mockapp.App{}, cache, fs)
 
-		actual, err := unit.GetAndResize(ctx, url, 100, 100, headers)
+		actual, err := unit.GetAndResize(ctx, url, opts, headers)
 		require.NoError(t, err)
-		require.EqualValues(t, result, actual)
+		require.Equal(t, expected, actual)
+		require.Equal(t, Stats{Storage: TierStats{Hits: 1}}, unit.Stats())
 	})
 
 	t.Run("miss cache", func(t *testing.T) {
-		w, h := 100, 100
-		result := []byte("success result")
+		result := app.NewImage([]byte("success result"), time.Now())
+		result.Header = http.Header{"Cache-Control": {"max-age=60"}}
 		var item *lru.Item
 		var fileName string
+		var metadata []byte
 
 		cache := &mocklru.Cache{}
 		cache.
@@ -81,31 +133,58 @@ func TestAppCacheDecorator_GetAndResize_Success(t *testing.T) {
 
 		appp := &mockapp.App{}
 		appp.
-			On("GetAndResize", ctx, url, w, h, headers).
+			On("GetAndResize", anyContext, url, opts, headers).
 			Once().
 			Return(result, nil)
 
 		fs := &mockfilesystem.Filesystem{}
 		fs.
-			On("WriteFile", anyFileName, result).
+			On("WriteFile", anyFileName, imageContent(result)).
 			Once().
 			Run(func(args mock.Arguments) {
 				fileName = args.String(0)
 			}).
 			Return(nil)
+		fs.
+			On("WriteFile", anyMetadata, anyContent).
+			Once().
+			Run(func(args mock.Arguments) {
+				metadata = args.Get(1).([]byte)
+			}).
+			Return(nil)
 
 		unit := createApp(appp, cache, fs)
 
-		actual, err := unit.GetAndResize(ctx, url, w, h, headers)
+		actual, err := unit.GetAndResize(ctx, url, opts, headers)
 		require.NoError(t, err)
-		require.EqualValues(t, result, actual)
+		require.Equal(t, result, actual)
 		require.Equal(t, fileName, item.FileName)
-		require.Equal(t, uint64(len(result)), item.Size)
+		require.True(t, strings.HasSuffix(fileName, ".jpg"))
+		require.Equal(t, uint64(len(imageContent(result))), item.Size)
+		require.Equal(t, uint64(len(metadata)), item.MetadataSize)
+		require.Equal(t, result.ETag, item.ETag)
+		require.Equal(t, result.ModTime, item.ModTime)
+		require.Equal(t, result.Header, item.Header)
+		require.Equal(t, Stats{Storage: TierStats{Misses: 1}}, unit.Stats())
+		require.Equal(t, url, item.URL)
+		require.Equal(t, "fill", item.Operation)
+		require.Equal(t, 100, item.Width)
+		require.Equal(t, 100, item.Height)
+		require.Equal(t, "image/jpeg", item.ContentType)
+		require.False(t, item.CreatedAt.IsZero())
+
+		stored := &lru.Item{}
+		require.NoError(t, json.Unmarshal(metadata, stored))
+		require.Equal(t, item.FileName, stored.FileName)
+		require.Equal(t, item.URL, stored.URL)
+		require.Equal(t, item.ETag, stored.ETag)
+		require.True(t, item.ModTime.Equal(stored.ModTime))
+		require.Equal(t, item.Header, stored.Header)
 	})
 }
 
 func TestAppCacheDecorator_GetAndResize_FS_Errors(t *testing.T) {
-	t.Run("read file", func(t *testing.T) {
+	t.Run("open file", func(t *testing.T) {
 		fileName := "some_file_name"
 		item := &lru.Item{
 			FileName: fileName,
@@ -120,21 +199,20 @@ func TestAppCacheDecorator_GetAndResize_FS_Errors(t *testing.T) {
 
 		fs := &mockfilesystem.Filesystem{}
 		fs.
-			On("ReadFile", fileName).
+			On("Open", fileName).
 			Once().
 			Return(nil, testError)
 
 		unit := createApp(&mockapp.App{}, cache, fs)
 
-		result, err := unit.GetAndResize(ctx, url, 100, 100, headers)
+		result, err := unit.GetAndResize(ctx, url, opts, headers)
 		require.Nil(t, result)
 		require.Error(t, err)
 		require.ErrorIs(t, err, testError)
 	})
 
 	t.Run("write file", func(t *testing.T) {
-		w, h := 100, 100
-		result := []byte("error result")
+		result := app.NewImage([]byte("error result"), time.Now())
 		testError := errors.New("test error")
 
 		cache := &mocklru.Cache{}
@@ -145,27 +223,117 @@ func TestAppCacheDecorator_GetAndResize_FS_Errors(t *testing.T) {
 
 		appp := &mockapp.App{}
 		appp.
-			On("GetAndResize", ctx, url, w, h, headers).
+			On("GetAndResize", anyContext, url, opts, headers).
 			Once().
 			Return(result, nil)
 
 		fs := &mockfilesystem.Filesystem{}
 		fs.
-			On("WriteFile", anyFileName, result).
+			On("WriteFile", anyFileName, imageContent(result)).
 			Once().
 			Return(testError)
 
 		unit := createApp(appp, cache, fs)
 
-		result, err := unit.GetAndResize(ctx, url, 100, 100, headers)
-		require.Nil(t, result)
+		actual, err := unit.GetAndResize(ctx, url, opts, headers)
+		require.Nil(t, actual)
 		require.Error(t, err)
 		require.ErrorIs(t, err, testError)
 	})
+
+	t.Run("write metadata", func(t *testing.T) {
+		result := app.NewImage([]byte("error result"), time.Now())
+		testError := errors.New("test error")
+		var fileName string
+
+		cache := &mocklru.Cache{}
+		cache.
+			On("Get", anyCacheKey).
+			Once().
+			Return(nil, false)
+
+		appp := &mockapp.App{}
+		appp.
+			On("GetAndResize", anyContext, url, opts, headers).
+			Once().
+			Return(result, nil)
+
+		fs := &mockfilesystem.Filesystem{}
+		fs.
+			On("WriteFile", anyFileName, imageContent(result)).
+			Once().
+			Run(func(args mock.Arguments) {
+				fileName = args.String(0)
+			}).
+			Return(nil)
+		fs.
+			On("WriteFile", anyMetadata, anyContent).
+			Once().
+			Return(testError)
+		fs.
+			On("RemoveFile", anyFileName).
+			Once().
+			Return(nil)
+
+		unit := createApp(appp, cache, fs)
+
+		actual, err := unit.GetAndResize(ctx, url, opts, headers)
+		require.Nil(t, actual)
+		require.ErrorIs(t, err, testError)
+		fs.AssertCalled(t, "RemoveFile", fileName)
+		cache.AssertNotCalled(t, "Set", anyCacheKey, anyCacheItem)
+	})
+}
+
+func TestAppCacheDecorator_GetAndResize_RemovedFile(t *testing.T) {
+	item := &lru.Item{FileName: "some_file_name"}
+	result := app.NewImage([]byte("success result"), time.Now())
+
+	cache := &mocklru.Cache{}
+	cache.
+		On("Get", anyCacheKey).
+		Once().
+		Return(item, true)
+	cache.
+		On("Remove", anyCacheKey).
+		Once().
+		Return(true)
+	cache.
+		On("Set", anyCacheKey, anyCacheItem).
+		Once().
+		Return(false)
+
+	appp := &mockapp.App{}
+	appp.
+		On("GetAndResize", anyContext, url, opts, headers).
+		Once().
+		Return(result, nil)
+
+	fs := &mockfilesystem.Filesystem{}
+	fs.
+		On("Open", item.FileName).
+		Once().
+		Return(nil, filesystem.ErrFileNotExists)
+	fs.
+		On("WriteFile", anyFileName, imageContent(result)).
+		Once().
+		Return(nil)
+	fs.
+		On("WriteFile", anyMetadata, anyContent).
+		Once().
+		Return(nil)
+
+	unit := createApp(appp, cache, fs)
+
+	actual, err := unit.GetAndResize(ctx, url, opts, headers)
+	require.NoError(t, err)
+	require.Equal(t, imageContent(result), readBody(t, actual))
+	require.Equal(t, Stats{Storage: TierStats{Misses: 1}}, unit.Stats())
+	cache.AssertExpectations(t)
+	fs.AssertExpectations(t)
 }
 
 func TestAppCacheDecorator_GetAndResize_App_Error(t *testing.T) {
-	w, h := 100, 100
 	testError := errors.New("test error")
 
 	cache := &mocklru.Cache{}
@@ -176,13 +344,226 @@ func TestAppCacheDecorator_GetAndResize_App_Error(t *testing.T) {
 
 	appp := &mockapp.App{}
 	appp.
-		On("GetAndResize", ctx, url, w, h, headers).
+		On("GetAndResize", anyContext, url, opts, headers).
 		Once().
 		Return(nil, testError)
 
 	unit := createApp(appp, cache, &mockfilesystem.Filesystem{})
-	result, err := unit.GetAndResize(ctx, url, 100, 100, headers)
+	result, err := unit.GetAndResize(ctx, url, opts, headers)
 	require.Nil(t, result)
 	require.Error(t, err)
 	require.ErrorIs(t, err, testError)
 }
+
+func TestAppCacheDecorator_GetAndResize_Oversized(t *testing.T) {
+	testData := []struct {
+		name        string
+		size        int
+		blockSize   uint64
+		maxItemSize uint64
+	}{
+		{name: "length", size: 101, maxItemSize: 100},
+		{name: "blocks", size: 9 * 1024, blockSize: 4096, maxItemSize: 10 * 1024},
+	}
+
+	for _, td := range testData {
+		td := td
+		t.Run(td.name, func(t *testing.T) {
+			result := app.NewImage(make([]byte, td.size), time.Now())
+
+			cache := &mocklru.Cache{}
+			cache.
+				On("Get", anyCacheKey).
+				Once().
+				Return(nil, false)
+
+			appp := &mockapp.App{}
+			appp.
+				On("GetAndResize", anyContext, url, opts, headers).
+				Once().
+				Return(result, nil)
+
+			fs := &mockfilesystem.Filesystem{}
+
+			unit := createApp(appp, cache, fs)
+			unit.maxItemSize = td.maxItemSize
+			unit.blockSize = td.blockSize
+
+			actual, err := unit.GetAndResize(ctx, url, opts, headers)
+			require.NoError(t, err)
+			require.Equal(t, result, actual)
+			require.Equal(t, Stats{Storage: TierStats{Misses: 1}, Oversized: 1}, unit.Stats())
+			fs.AssertNotCalled(t, "WriteFile", anyFileName, anyContent)
+			cache.AssertNotCalled(t, "Set", anyCacheKey, anyCacheItem)
+		})
+	}
+}
+
+func TestAppCacheDecorator_GetAndResize_OversizedLowWatermark(t *testing.T) {
+	unit, err := NewCacheAppDecorator(&widthApp{}, Limits{Size: 4000, LowWatermark: 0.5}, filesystem.NewMemoryFilesystem())
+	require.NoError(t, err)
+
+	for width := 10; width < 18; width++ {
+		getWidth(t, unit, width)
+	}
+	require.Len(t, unit.Entries(), 8)
+
+	// The preview fits the size but not the low watermark, it would flush the whole cache.
+	getWidth(t, unit, 2500)
+	require.Len(t, unit.Entries(), 8)
+	require.EqualValues(t, 1, unit.Stats().Oversized)
+}
+
+type volumeStub struct {
+	free []uint64
+	err  error
+}
+
+func (v *volumeStub) Stat() (filesystem.VolumeStat, error) {
+	if v.err != nil {
+		return filesystem.VolumeStat{}, v.err
+	}
+
+	free := v.free[0]
+	if len(v.free) > 1 {
+		v.free = v.free[1:]
+	}
+
+	return filesystem.VolumeStat{BlockSize: 4096, Free: free}, nil
+}
+
+func TestAppCacheDecorator_GetAndResize_FreeSpace(t *testing.T) {
+	content := make([]byte, 100)
+
+	testData := []struct {
+		name      string
+		volume    *volumeStub
+		evictions int
+		empty     bool
+		cached    bool
+	}{
+		{name: "enough space", volume: &volumeStub{free: []uint64{2000}}, cached: true},
+		{name: "freed", volume: &volumeStub{free: []uint64{500, 1050, 2000}}, evictions: 2, cached: true},
+		{name: "cache is empty", volume: &volumeStub{free: []uint64{500, 900}}, evictions: 1, empty: true},
+		{name: "stat error", volume: &volumeStub{err: errors.New("test error")}},
+	}
+
+	for _, td := range testData {
+		td := td
+		t.Run(td.name, func(t *testing.T) {
+			result := app.NewImage(content, time.Now())
+
+			cache := &mocklru.Cache{}
+			cache.
+				On("Get", anyCacheKey).
+				Once().
+				Return(nil, false)
+			if td.evictions > 0 {
+				cache.
+					On("RemoveOldest").
+					Times(td.evictions).
+					Return(true)
+			}
+			if td.empty {
+				cache.
+					On("RemoveOldest").
+					Once().
+					Return(false)
+			}
+
+			appp := &mockapp.App{}
+			appp.
+				On("GetAndResize", anyContext, url, opts, headers).
+				Once().
+				Return(result, nil)
+
+			fs := &mockfilesystem.Filesystem{}
+
+			if td.cached {
+				cache.
+					On("Set", anyCacheKey, anyCacheItem).
+					Once().
+					Return(false)
+				fs.
+					On("WriteFile", anyFileName, content).
+					Once().
+					Return(nil)
+				fs.
+					On("WriteFile", anyMetadata, anyContent).
+					Once().
+					Return(nil)
+			}
+
+			unit := createApp(appp, cache, fs)
+			unit.volume = td.volume
+			unit.minFreeSpace = 1000
+
+			actual, err := unit.GetAndResize(ctx, url, opts, headers)
+			require.NoError(t, err)
+			require.Equal(t, result, actual)
+
+			cache.AssertExpectations(t)
+			fs.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAppCacheDecorator_GetAndResize_Vary(t *testing.T) {
+	testData := []struct {
+		vary      []string
+		cacheable bool
+	}{
+		{nil, true},
+		{[]string{"Accept-Encoding"}, true},
+		{[]string{"accept-language", "Accept-Encoding"}, true},
+		{[]string{"Cookie"}, false},
+		{[]string{"Accept-Language", "User-Agent"}, false},
+		{[]string{"*"}, false},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			result := app.NewImage([]byte("success result"), time.Now())
+			result.Vary = td.vary
+
+			cache := &mocklru.Cache{}
+			cache.
+				On("Get", anyCacheKey).
+				Once().
+				Return(nil, false)
+
+			appp := &mockapp.App{}
+			appp.
+				On("GetAndResize", anyContext, url, opts, headers).
+				Once().
+				Return(result, nil)
+
+			fs := &mockfilesystem.Filesystem{}
+
+			if td.cacheable {
+				cache.
+					On("Set", anyCacheKey, anyCacheItem).
+					Once().
+					Return(false)
+				fs.
+					On("WriteFile", anyFileName, imageContent(result)).
+					Once().
+					Return(nil)
+				fs.
+					On("WriteFile", anyMetadata, anyContent).
+					Once().
+					Return(nil)
+			}
+
+			unit := createApp(appp, cache, fs)
+
+			actual, err := unit.GetAndResize(ctx, url, opts, headers)
+			require.NoError(t, err)
+			require.Equal(t, result, actual)
+
+			cache.AssertExpectations(t)
+			fs.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package filesystem
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testConformance checks the behavior every Filesystem must provide.
+func testConformance(t *testing.T, newFilesystem func(t *testing.T) Filesystem) {
+	t.Helper()
+
+	t.Run("write and read", func(t *testing.T) {
+		fs := newFilesystem(t)
+		content := []byte{0, 1, 2, 0xff, '\n'}
+
+		require.NoError(t, fs.WriteFile("abcdef.jpg", content))
+
+		actual, err := fs.ReadFile("abcdef.jpg")
+		require.NoError(t, err)
+		require.Equal(t, content, actual)
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		fs := newFilesystem(t)
+
+		require.NoError(t, fs.WriteFile("abcdef.jpg", []byte{}))
+
+		actual, err := fs.ReadFile("abcdef.jpg")
+		require.NoError(t, err)
+		require.Empty(t, actual)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		fs := newFilesystem(t)
+
+		require.NoError(t, fs.WriteFile("abcdef.jpg", []byte("first")))
+		require.NoError(t, fs.WriteFile("abcdef.jpg", []byte("second")))
+
+		actual, err := fs.ReadFile("abcdef.jpg")
+		require.NoError(t, err)
+		require.Equal(t, []byte("second"), actual)
+	})
+
+	t.Run("written content is copied", func(t *testing.T) {
+		fs := newFilesystem(t)
+		content := []byte("content")
+
+		require.NoError(t, fs.WriteFile("abcdef.jpg", content))
+		content[0] = 'C'
+
+		actual, err := fs.ReadFile("abcdef.jpg")
+		require.NoError(t, err)
+		require.Equal(t, []byte("content"), actual)
+	})
+
+	t.Run("read missing", func(t *testing.T) {
+		fs := newFilesystem(t)
+
+		_, err := fs.ReadFile("abcdef.jpg")
+		require.ErrorIs(t, err, ErrFileNotExists)
+	})
+
+	t.Run("open", func(t *testing.T) {
+		fs := newFilesystem(t)
+		content := []byte("0123456789")
+		require.NoError(t, fs.WriteFile("abcdef.jpg", content))
+
+		file, err := fs.Open("abcdef.jpg")
+		require.NoError(t, err)
+		defer file.Close()
+
+		size, err := file.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		require.EqualValues(t, len(content), size)
+
+		_, err = file.Seek(4, io.SeekStart)
+		require.NoError(t, err)
+		actual, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, []byte("456789"), actual)
+
+		// The opened file keeps its content.
+		require.NoError(t, fs.RemoveFile("abcdef.jpg"))
+		_, err = file.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		actual, err = io.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, content, actual)
+	})
+
+	t.Run("open missing", func(t *testing.T) {
+		fs := newFilesystem(t)
+
+		_, err := fs.Open("abcdef.jpg")
+		require.ErrorIs(t, err, ErrFileNotExists)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		fs := newFilesystem(t)
+
+		require.NoError(t, fs.WriteFile("abcdef.jpg", []byte("content")))
+		require.NoError(t, fs.WriteFile("abcdef.json", []byte("metadata")))
+		require.NoError(t, fs.RemoveFile("abcdef.jpg"))
+
+		_, err := fs.ReadFile("abcdef.jpg")
+		require.ErrorIs(t, err, ErrFileNotExists)
+
+		actual, err := fs.ReadFile("abcdef.json")
+		require.NoError(t, err)
+		require.Equal(t, []byte("metadata"), actual)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		fs := newFilesystem(t)
+		before := time.Now().Add(-time.Minute)
+
+		files, err := fs.List()
+		require.NoError(t, err)
+		require.Empty(t, files)
+
+		require.NoError(t, fs.WriteFile("abcdef.jpg", []byte("content")))
+		require.NoError(t, fs.WriteFile("abcdef.json", []byte("metadata")))
+		require.NoError(t, fs.WriteFile("ab.png", []byte("short")))
+		require.NoError(t, fs.WriteFile("removed.gif", []byte("removed")))
+		require.NoError(t, fs.RemoveFile("removed.gif"))
+
+		files, err = fs.List()
+		require.NoError(t, err)
+
+		sizes := make(map[string]uint64, len(files))
+		for _, file := range files {
+			sizes[file.Name] = file.Size
+			require.True(t, file.ModTime.After(before), file.Name)
+		}
+		require.Equal(t, map[string]uint64{"abcdef.jpg": 7, "abcdef.json": 8, "ab.png": 5}, sizes)
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		fs := newFilesystem(t)
+		contents := [][]byte{bytes.Repeat([]byte("a"), 1<<16), bytes.Repeat([]byte("b"), 1<<15)}
+		require.NoError(t, fs.WriteFile("shared.jpg", contents[0]))
+
+		const goroutines = 8
+		errs := make(chan error, goroutines*2)
+		wg := sync.WaitGroup{}
+		wg.Add(goroutines)
+		for g := 0; g < goroutines; g++ {
+			go func(g int) {
+				defer wg.Done()
+
+				name := "file" + strconv.Itoa(g) + ".jpg"
+				errs <- fs.WriteFile(name, contents[g%2])
+				errs <- fs.WriteFile("shared.jpg", contents[g%2])
+
+				if actual, err := fs.ReadFile("shared.jpg"); err != nil {
+					errs <- err
+				} else if !bytes.Equal(actual, contents[0]) && !bytes.Equal(actual, contents[1]) {
+					errs <- os.ErrInvalid
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		files, err := fs.List()
+		require.NoError(t, err)
+		require.Len(t, files, goroutines+1)
+	})
+}
+
+func TestDiscFilesystem_Conformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) Filesystem {
+		t.Helper()
+
+		fs, err := NewDiskFilesystem(t.TempDir())
+		require.NoError(t, err)
+
+		return fs
+	})
+}
+
+func TestMemoryFilesystem_Conformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) Filesystem {
+		t.Helper()
+
+		return NewMemoryFilesystem()
+	})
+}
+
+func TestS3Filesystem_Conformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) Filesystem {
+		t.Helper()
+
+		_, server := newS3Stub(t)
+
+		return newTestS3Filesystem(t, server.URL, "previews/")
+	})
+}
@@ -3,24 +3,43 @@ package filesystem
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 var _ Filesystem = (*DiscFilesystem)(nil)
 
 var ErrFileNotExists = errors.New("file is not exists")
 
-const filePermission = 0o700
+const (
+	filePermission     = 0o700
+	tempFilePrefix     = "."
+	tempFileSuffix     = ".tmp"
+	createTempAttempts = 3
+)
 
 type Filesystem interface {
 	WriteFile(name string, content []byte) error
 	ReadFile(name string) ([]byte, error)
+	// Open opens the file for streaming, the file stays readable after its removal until it is closed.
+	Open(name string) (io.ReadSeekCloser, error)
 	RemoveFile(name string) error
+	List() ([]FileInfo, error)
+}
+
+type FileInfo struct {
+	Name    string
+	Size    uint64
+	ModTime time.Time
 }
 
 type DiscFilesystem struct {
 	basePath string
+	// shared is set when other processes may use the base path at once.
+	shared bool
 }
 
 func NewDiskFilesystem(basePath string) (*DiscFilesystem, error) {
@@ -28,23 +47,85 @@ func NewDiskFilesystem(basePath string) (*DiscFilesystem, error) {
 		return nil, fmt.Errorf("new filesystem: %w", err)
 	}
 
-	return &DiscFilesystem{
+	f := &DiscFilesystem{
 		basePath: basePath,
-	}, nil
+	}
+
+	if err := f.tidy(); err != nil {
+		return nil, fmt.Errorf("new filesystem: %w", err)
+	}
+
+	return f, nil
 }
 
+// WriteFile writes the content to a temp file and renames it to the name, so the readers never see
+// a partially written file.
 func (f *DiscFilesystem) WriteFile(name string, content []byte) error {
-	path := filepath.Join(f.basePath, name)
+	path := f.path(name)
+
+	temp, err := f.createTemp(name)
+	if err != nil {
+		return fmt.Errorf("filesystem write file %s: %w", path, err)
+	}
+
+	if err := writeTempFile(temp, content); err != nil {
+		_ = os.Remove(temp.Name())
+
+		return fmt.Errorf("filesystem write file %s: %w", path, err)
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		_ = os.Remove(temp.Name())
 
-	if err := os.WriteFile(path, content, filePermission); err != nil {
 		return fmt.Errorf("filesystem write file %s: %w", path, err)
 	}
 
 	return nil
 }
 
+// createTemp creates the temp file in the directory of the name, creating the directory if needed.
+func (f *DiscFilesystem) createTemp(name string) (*os.File, error) {
+	dir := f.dir(name)
+
+	for attempt := 1; ; attempt++ {
+		var temp *os.File
+		err := os.MkdirAll(dir, filePermission)
+		if err == nil {
+			temp, err = os.CreateTemp(dir, tempFilePrefix+name+".*"+tempFileSuffix)
+		}
+
+		// A concurrent removal of the last file of the directory may remove the directory in between.
+		if err == nil || !os.IsNotExist(err) || attempt == createTempAttempts {
+			return temp, err
+		}
+	}
+}
+
+func writeTempFile(temp *os.File, content []byte) error {
+	if _, err := temp.Write(content); err != nil {
+		_ = temp.Close()
+
+		return err
+	}
+
+	if err := temp.Chmod(filePermission); err != nil {
+		_ = temp.Close()
+
+		return err
+	}
+
+	// The content must reach the disk before the rename does, or a crash may leave an empty file.
+	if err := temp.Sync(); err != nil {
+		_ = temp.Close()
+
+		return err
+	}
+
+	return temp.Close()
+}
+
 func (f *DiscFilesystem) ReadFile(name string) ([]byte, error) {
-	path := filepath.Join(f.basePath, name)
+	path := f.path(name)
 
 	content, err := os.ReadFile(path)
 	if err != nil {
@@ -58,16 +139,70 @@ func (f *DiscFilesystem) ReadFile(name string) ([]byte, error) {
 	return content, nil
 }
 
+func (f *DiscFilesystem) Open(name string) (io.ReadSeekCloser, error) {
+	path := f.path(name)
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrFileNotExists
+		}
+
+		return nil, fmt.Errorf("filesystem open file %s: %w", path, err)
+	}
+
+	return file, nil
+}
+
 func (f *DiscFilesystem) RemoveFile(name string) error {
-	path := filepath.Join(f.basePath, name)
+	path := f.path(name)
 
 	if err := os.Remove(path); err != nil {
 		return fmt.Errorf("remove file %s: %w", path, err)
 	}
 
+	f.removeEmptyDirs(filepath.Dir(path))
+
 	return nil
 }
 
+// List returns the regular files of the base path and its shard directories.
+func (f *DiscFilesystem) List() ([]FileInfo, error) {
+	paths, _, err := f.walk()
+	if err != nil {
+		return nil, fmt.Errorf("filesystem list %s: %w", f.basePath, err)
+	}
+
+	files := make([]FileInfo, 0, len(paths))
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if isTempFile(name) || isLockFile(name) {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, fmt.Errorf("filesystem list %s: %w", path, err)
+		}
+
+		files = append(files, FileInfo{
+			Name:    name,
+			Size:    uint64(info.Size()),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	return files, nil
+}
+
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix) && strings.HasSuffix(name, tempFileSuffix)
+}
+
 func ensureDir(dir string) error {
 	if _, err := os.Stat(dir); !os.IsNotExist(err) {
 		return nil
@@ -1,8 +1,14 @@
 package filesystem
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -59,6 +65,302 @@ func (ts *FilesystemTestSuite) TestComplex() {
 	require.ErrorIs(ts.T(), err, ErrFileNotExists)
 }
 
+func (ts *FilesystemTestSuite) TestList() {
+	fs, _ := NewDiskFilesystem(ts.basePath)
+
+	files, err := fs.List()
+	require.NoError(ts.T(), err)
+	require.Empty(ts.T(), files)
+
+	require.NoError(ts.T(), fs.WriteFile("file.txt", []byte("content")))
+	require.NoError(ts.T(), os.Mkdir(filepath.Join(ts.basePath, "dir"), filePermission))
+
+	files, err = fs.List()
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), files, 1)
+	require.Equal(ts.T(), "file.txt", files[0].Name)
+	require.EqualValues(ts.T(), len("content"), files[0].Size)
+	require.False(ts.T(), files[0].ModTime.IsZero())
+}
+
+func (ts *FilesystemTestSuite) TestTempFiles() {
+	temp := filepath.Join(ts.basePath, ".file.jpg.123.tmp")
+	require.NoError(ts.T(), os.WriteFile(temp, []byte("partial"), filePermission))
+	require.NoError(ts.T(), os.WriteFile(filepath.Join(ts.basePath, "file.jpg"), []byte("content"), filePermission))
+
+	fs, err := NewDiskFilesystem(ts.basePath)
+	require.NoError(ts.T(), err)
+
+	_, err = os.Stat(temp)
+	require.True(ts.T(), os.IsNotExist(err))
+
+	require.NoError(ts.T(), os.WriteFile(temp, []byte("partial"), filePermission))
+	files, err := fs.List()
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), files, 1)
+	require.Equal(ts.T(), "file.jpg", files[0].Name)
+}
+
+func (ts *FilesystemTestSuite) TestConcurrentReadWrite() {
+	fs, _ := NewDiskFilesystem(ts.basePath)
+	name := "file.jpg"
+	contents := [][]byte{
+		bytes.Repeat([]byte("a"), 1<<20),
+		bytes.Repeat([]byte("b"), 1<<19),
+	}
+	require.NoError(ts.T(), fs.WriteFile(name, contents[0]))
+
+	const writes = 50
+	var writeErr error
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+
+		for i := 0; i < writes && writeErr == nil; i++ {
+			writeErr = fs.WriteFile(name, contents[i%2])
+		}
+	}()
+
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+
+		actual, err := fs.ReadFile(name)
+		require.NoError(ts.T(), err)
+		require.True(ts.T(), bytes.Equal(contents[0], actual) || bytes.Equal(contents[1], actual))
+	}
+	wg.Wait()
+	require.NoError(ts.T(), writeErr)
+
+	files, _, err := fs.walk()
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), files, 1)
+}
+
+func (ts *FilesystemTestSuite) TestLayout() {
+	fs, _ := NewDiskFilesystem(ts.basePath)
+	content := []byte("content")
+
+	require.NoError(ts.T(), fs.WriteFile("abcdef.jpg", content))
+	require.NoError(ts.T(), fs.WriteFile("abcdef.json", content))
+	require.NoError(ts.T(), fs.WriteFile("abxyz.jpg", content))
+	require.NoError(ts.T(), fs.WriteFile("abc.jpg", content))
+
+	require.FileExists(ts.T(), filepath.Join(ts.basePath, "ab", "cd", "abcdef.jpg"))
+	require.FileExists(ts.T(), filepath.Join(ts.basePath, "ab", "cd", "abcdef.json"))
+	require.FileExists(ts.T(), filepath.Join(ts.basePath, "ab", "xy", "abxyz.jpg"))
+	require.FileExists(ts.T(), filepath.Join(ts.basePath, "abc.jpg"))
+
+	actual, err := fs.ReadFile("abcdef.jpg")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), content, actual)
+
+	require.NoError(ts.T(), fs.RemoveFile("abcdef.jpg"))
+	require.DirExists(ts.T(), filepath.Join(ts.basePath, "ab", "cd"))
+
+	require.NoError(ts.T(), fs.RemoveFile("abcdef.json"))
+	require.NoDirExists(ts.T(), filepath.Join(ts.basePath, "ab", "cd"))
+	require.DirExists(ts.T(), filepath.Join(ts.basePath, "ab"))
+
+	require.NoError(ts.T(), fs.RemoveFile("abxyz.jpg"))
+	require.NoDirExists(ts.T(), filepath.Join(ts.basePath, "ab"))
+
+	require.NoError(ts.T(), fs.RemoveFile("abc.jpg"))
+	require.DirExists(ts.T(), ts.basePath)
+}
+
+func (ts *FilesystemTestSuite) TestConcurrentLayout() {
+	fs, _ := NewDiskFilesystem(ts.basePath)
+
+	const goroutines, iterations = 8, 100
+	errs := make(chan error, goroutines)
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(name string) {
+			defer wg.Done()
+
+			// All the names share the shard directories, which get removed and created over again.
+			for i := 0; i < iterations; i++ {
+				if err := fs.WriteFile(name, []byte(name)); err != nil {
+					errs <- err
+					return
+				}
+				if err := fs.RemoveFile(name); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}("abcd" + strconv.Itoa(g) + ".jpg")
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(ts.T(), err)
+	}
+}
+
+func (ts *FilesystemTestSuite) TestMigration() {
+	content := []byte("content")
+	write := func(path ...string) {
+		path = append([]string{ts.basePath}, path...)
+		require.NoError(ts.T(), os.MkdirAll(filepath.Join(path[:len(path)-1]...), filePermission))
+		require.NoError(ts.T(), os.WriteFile(filepath.Join(path...), content, filePermission))
+	}
+
+	write("abcdef.jpg")
+	write(".abcdef.jpg.123.tmp")
+	write("ab", "cd", ".abcdef.jpg.456.tmp")
+	write("zz", "misplaced.jpg")
+	write("foreign", "file.jpg")
+	require.NoError(ts.T(), os.MkdirAll(filepath.Join(ts.basePath, "yy", "xx"), filePermission))
+
+	fs, err := NewDiskFilesystem(ts.basePath)
+	require.NoError(ts.T(), err)
+
+	files, _, err := fs.walk()
+	require.NoError(ts.T(), err)
+	require.ElementsMatch(ts.T(), []string{
+		filepath.Join(ts.basePath, "ab", "cd", "abcdef.jpg"),
+		filepath.Join(ts.basePath, "mi", "sp", "misplaced.jpg"),
+	}, files)
+
+	require.NoDirExists(ts.T(), filepath.Join(ts.basePath, "yy"))
+	require.NoDirExists(ts.T(), filepath.Join(ts.basePath, "zz"))
+	require.FileExists(ts.T(), filepath.Join(ts.basePath, "foreign", "file.jpg"))
+
+	list, err := fs.List()
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), list, 2)
+}
+
+func (ts *FilesystemTestSuite) TestStat() {
+	fs, _ := NewDiskFilesystem(ts.basePath)
+
+	stat, err := fs.Stat()
+	if errors.Is(err, ErrVolumeStatNotSupported) {
+		ts.T().Skip(err.Error())
+	}
+	require.NoError(ts.T(), err)
+	require.NotZero(ts.T(), stat.BlockSize)
+	require.NotZero(ts.T(), stat.Free)
+}
+
+func (ts *FilesystemTestSuite) TestSharedTempFiles() {
+	fresh := filepath.Join(ts.basePath, ".file.jpg.123.tmp")
+	stale := filepath.Join(ts.basePath, ".file.jpg.456.tmp")
+	require.NoError(ts.T(), os.WriteFile(fresh, []byte("partial"), filePermission))
+	require.NoError(ts.T(), os.WriteFile(stale, []byte("partial"), filePermission))
+	staleTime := time.Now().Add(-2 * staleTempFileAge)
+	require.NoError(ts.T(), os.Chtimes(stale, staleTime, staleTime))
+
+	ts.newShared()
+
+	_, err := os.Stat(fresh)
+	require.NoError(ts.T(), err, "the write may be in progress in another process")
+	_, err = os.Stat(stale)
+	require.True(ts.T(), os.IsNotExist(err))
+}
+
+// newShared creates the shared filesystem of the base path, the test is skipped where sharing is not supported.
+func (ts *FilesystemTestSuite) newShared() *DiscFilesystem {
+	fs, err := NewSharedDiskFilesystem(ts.basePath)
+	if errors.Is(err, ErrSharedNotSupported) {
+		ts.T().Skip(err.Error())
+	}
+	require.NoError(ts.T(), err)
+
+	return fs
+}
+
+func (ts *FilesystemTestSuite) TestSharedConstructor() {
+	_, err := NewSharedDiskFilesystem(ts.basePath)
+	if locksSupported {
+		require.NoError(ts.T(), err)
+	} else {
+		require.ErrorIs(ts.T(), err, ErrSharedNotSupported)
+	}
+}
+
+func (ts *FilesystemTestSuite) TestTryLock() {
+	fs := ts.newShared()
+
+	unlock, err := fs.TryLock("leader")
+	require.NoError(ts.T(), err)
+
+	// The lock is held by the open file, so the other filesystems contend for it like other processes.
+	other := ts.newShared()
+	_, err = other.TryLock("leader")
+	require.ErrorIs(ts.T(), err, ErrLocked)
+
+	unlockOther, err := other.TryLock("other")
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), unlockOther())
+
+	files, err := fs.List()
+	require.NoError(ts.T(), err)
+	require.Empty(ts.T(), files, "lock files are hidden")
+
+	require.NoError(ts.T(), unlock())
+	unlock, err = other.TryLock("leader")
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), unlock())
+}
+
+func (ts *FilesystemTestSuite) TestLock() {
+	fs := ts.newShared()
+	other := ts.newShared()
+
+	unlockShared, err := fs.Lock("index", false)
+	require.NoError(ts.T(), err)
+
+	unlockOtherShared, err := other.Lock("index", false)
+	require.NoError(ts.T(), err, "shared locks do not exclude each other")
+	require.NoError(ts.T(), unlockOtherShared())
+
+	locked := make(chan func() error)
+	go func() {
+		unlock, _ := other.Lock("index", true)
+		locked <- unlock
+	}()
+
+	select {
+	case <-locked:
+		ts.T().Fatal("exclusive lock taken while the shared one is held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(ts.T(), unlockShared())
+	unlock := <-locked
+	require.NotNil(ts.T(), unlock)
+
+	_, err = fs.TryLock("index")
+	require.ErrorIs(ts.T(), err, ErrLocked)
+	require.NoError(ts.T(), unlock())
+}
+
+func (ts *FilesystemTestSuite) TestTouch() {
+	fs := ts.newShared()
+	require.ErrorIs(ts.T(), fs.Touch("abcdef.jpg"), ErrFileNotExists)
+
+	require.NoError(ts.T(), fs.WriteFile("abcdef.jpg", []byte("content")))
+	old := time.Now().Add(-time.Hour)
+	require.NoError(ts.T(), os.Chtimes(fs.path("abcdef.jpg"), old, old))
+
+	require.NoError(ts.T(), fs.Touch("abcdef.jpg"))
+	files, err := fs.List()
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), files, 1)
+	require.True(ts.T(), files[0].ModTime.After(old))
+}
+
 func TestFilesystemTestSuite(t *testing.T) {
 	suite.Run(t, new(FilesystemTestSuite))
 }
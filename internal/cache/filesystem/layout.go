@@ -0,0 +1,151 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	shardLevels = 2
+	shardWidth  = 2
+)
+
+// dir returns the shard directory of the file named by the first characters of its name,
+// e.g. ab/cd for abcdef.jpg. The names too short to shard are kept in the base path.
+func (f *DiscFilesystem) dir(name string) string {
+	stem := name
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		stem = name[:dot]
+	}
+
+	if len(stem) < shardLevels*shardWidth {
+		return f.basePath
+	}
+
+	parts := make([]string, 0, shardLevels+1)
+	parts = append(parts, f.basePath)
+	for level := 0; level < shardLevels; level++ {
+		parts = append(parts, stem[level*shardWidth:(level+1)*shardWidth])
+	}
+
+	return filepath.Join(parts...)
+}
+
+func (f *DiscFilesystem) path(name string) string {
+	return filepath.Join(f.dir(name), name)
+}
+
+// walk returns the paths of the regular files and the shard directories under the base path.
+// Other directories are skipped.
+func (f *DiscFilesystem) walk() ([]string, []string, error) {
+	var files, dirs []string
+
+	err := filepath.WalkDir(f.basePath, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		switch {
+		case path == f.basePath:
+		case entry.IsDir():
+			if !f.isShardDir(path) {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+		case entry.Type().IsRegular():
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	return files, dirs, err
+}
+
+func (f *DiscFilesystem) isShardDir(path string) bool {
+	rel, err := filepath.Rel(f.basePath, path)
+	if err != nil {
+		return false
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) > shardLevels {
+		return false
+	}
+
+	for _, part := range parts {
+		if len(part) != shardWidth {
+			return false
+		}
+	}
+
+	return true
+}
+
+// tidy removes the temp files left by the writes interrupted by a crash, moves the files of the
+// flat layout to their shard directories and removes the empty shard directories.
+func (f *DiscFilesystem) tidy() error {
+	files, dirs, err := f.walk()
+	if err != nil {
+		return fmt.Errorf("tidy %s: %w", f.basePath, err)
+	}
+
+	for _, path := range files {
+		name := filepath.Base(path)
+
+		if isLockFile(name) {
+			continue
+		}
+
+		if isTempFile(name) {
+			if !f.isStaleTempFile(path) {
+				continue
+			}
+
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove temp file: %w", err)
+			}
+
+			continue
+		}
+
+		if target := f.path(name); target != path {
+			if err := os.MkdirAll(filepath.Dir(target), filePermission); err != nil {
+				return fmt.Errorf("move file %s: %w", path, err)
+			}
+
+			// Another process sharing the base path may have moved the file already.
+			if err := os.Rename(path, target); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("move file %s: %w", path, err)
+			}
+		}
+	}
+
+	// The nested directories go first, so their parents get empty before being removed.
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+	for _, dir := range dirs {
+		_ = os.Remove(dir)
+	}
+
+	return nil
+}
+
+// removeEmptyDirs removes the directory and its parents up to the base path while they are empty.
+func (f *DiscFilesystem) removeEmptyDirs(dir string) {
+	for dir != f.basePath && f.isShardDir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+
+		dir = filepath.Dir(dir)
+	}
+}
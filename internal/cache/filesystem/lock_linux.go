@@ -0,0 +1,55 @@
+//go:build linux
+// +build linux
+
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const locksSupported = true
+
+func (f *DiscFilesystem) TryLock(name string) (func() error, error) {
+	unlock, err := f.flock(name, syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return nil, ErrLocked
+	}
+
+	return unlock, err
+}
+
+func (f *DiscFilesystem) Lock(name string, exclusive bool) (func() error, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	return f.flock(name, how)
+}
+
+func (f *DiscFilesystem) flock(name string, how int) (func() error, error) {
+	path := f.lockPath(name)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, filePermission)
+	if err != nil {
+		return nil, fmt.Errorf("filesystem lock %s: %w", path, err)
+	}
+
+	for {
+		err = syscall.Flock(int(file.Fd()), how)
+		if !errors.Is(err, syscall.EINTR) {
+			break
+		}
+	}
+	if err != nil {
+		_ = file.Close()
+
+		return nil, fmt.Errorf("filesystem lock %s: %w", path, err)
+	}
+
+	// Closing the file releases the lock, the lock file stays for the next holders.
+	return file.Close, nil
+}
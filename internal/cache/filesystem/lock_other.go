@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package filesystem
+
+const locksSupported = false
+
+func (f *DiscFilesystem) TryLock(name string) (func() error, error) {
+	return nil, ErrSharedNotSupported
+}
+
+func (f *DiscFilesystem) Lock(name string, exclusive bool) (func() error, error) {
+	return nil, ErrSharedNotSupported
+}
@@ -0,0 +1,106 @@
+package filesystem
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var _ Filesystem = (*MemoryFilesystem)(nil)
+
+// MemoryFilesystem keeps the files in memory, the files are lost on restart.
+type MemoryFilesystem struct {
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	content []byte
+	modTime time.Time
+}
+
+func NewMemoryFilesystem() *MemoryFilesystem {
+	return &MemoryFilesystem{
+		files: make(map[string]memoryFile),
+	}
+}
+
+func (f *MemoryFilesystem) WriteFile(name string, content []byte) error {
+	file := memoryFile{
+		content: append([]byte(nil), content...),
+		modTime: time.Now(),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.files[name] = file
+
+	return nil
+}
+
+func (f *MemoryFilesystem) ReadFile(name string) ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	file, ok := f.files[name]
+	if !ok {
+		return nil, ErrFileNotExists
+	}
+
+	// The content is never modified in place, so it is safe to share with the readers.
+	return file.content, nil
+}
+
+func (f *MemoryFilesystem) Open(name string) (io.ReadSeekCloser, error) {
+	content, err := f.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newContentReader(content), nil
+}
+
+func (f *MemoryFilesystem) RemoveFile(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.files[name]; !ok {
+		return fmt.Errorf("remove file %s: %w", name, ErrFileNotExists)
+	}
+
+	delete(f.files, name)
+
+	return nil
+}
+
+func (f *MemoryFilesystem) List() ([]FileInfo, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	files := make([]FileInfo, 0, len(f.files))
+	for name, file := range f.files {
+		files = append(files, FileInfo{
+			Name:    name,
+			Size:    uint64(len(file.content)),
+			ModTime: file.modTime,
+		})
+	}
+
+	return files, nil
+}
+
+// contentReader streams the content held in memory.
+type contentReader struct {
+	*bytes.Reader
+}
+
+func newContentReader(content []byte) contentReader {
+	return contentReader{bytes.NewReader(content)}
+}
+
+func (contentReader) Close() error {
+	return nil
+}
@@ -2,13 +2,63 @@
 
 package mockfilesystem
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	filesystem "github.com/pustato/image-previewer/internal/cache/filesystem"
+	mock "github.com/stretchr/testify/mock"
+	io "io"
+)
 
 // Filesystem is an autogenerated mock type for the Filesystem type
 type Filesystem struct {
 	mock.Mock
 }
 
+// List provides a mock function with given fields:
+func (_m *Filesystem) List() ([]filesystem.FileInfo, error) {
+	ret := _m.Called()
+
+	var r0 []filesystem.FileInfo
+	if rf, ok := ret.Get(0).(func() []filesystem.FileInfo); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]filesystem.FileInfo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Open provides a mock function with given fields: name
+func (_m *Filesystem) Open(name string) (io.ReadSeekCloser, error) {
+	ret := _m.Called(name)
+
+	var r0 io.ReadSeekCloser
+	if rf, ok := ret.Get(0).(func(string) io.ReadSeekCloser); ok {
+		r0 = rf(name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(io.ReadSeekCloser)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ReadFile provides a mock function with given fields: name
 func (_m *Filesystem) ReadFile(name string) ([]byte, error) {
 	ret := _m.Called(name)
@@ -0,0 +1,239 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var _ Filesystem = (*S3Filesystem)(nil)
+
+var ErrS3Config = errors.New("invalid s3 config")
+
+const (
+	s3Timeout       = 10 * time.Second
+	s3ErrorBodySize = 4 << 10
+)
+
+type S3Config struct {
+	// Endpoint is the URL of the S3-compatible server, e.g. https://s3.amazonaws.com.
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to the names of the files to get the object keys, it is required and ends with
+	// a slash. The cache owns every object under the prefix and removes the ones it does not know.
+	Prefix string
+}
+
+// S3Filesystem stores the files as the objects of an S3-compatible bucket addressed path-style.
+type S3Filesystem struct {
+	client   *http.Client
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	signer   *sigV4Signer
+}
+
+// S3Error is an error response of the S3 server.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 responded %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("s3 responded %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func NewS3Filesystem(config S3Config) (*S3Filesystem, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("new s3 filesystem: endpoint %q: %w", config.Endpoint, ErrS3Config)
+	}
+
+	if config.Bucket == "" || config.Region == "" {
+		return nil, fmt.Errorf("new s3 filesystem: bucket and region are required: %w", ErrS3Config)
+	}
+
+	prefix := strings.TrimSuffix(config.Prefix, "/")
+	if prefix == "" {
+		return nil, fmt.Errorf("new s3 filesystem: prefix is required: %w", ErrS3Config)
+	}
+
+	return &S3Filesystem{
+		client:   &http.Client{Timeout: s3Timeout},
+		endpoint: endpoint,
+		bucket:   config.Bucket,
+		prefix:   prefix + "/",
+		signer: &sigV4Signer{
+			accessKey: config.AccessKey,
+			secretKey: config.SecretKey,
+			region:    config.Region,
+		},
+	}, nil
+}
+
+func (f *S3Filesystem) WithClient(client *http.Client) *S3Filesystem {
+	f.client = client
+
+	return f
+}
+
+func (f *S3Filesystem) WriteFile(name string, content []byte) error {
+	rsp, err := f.do(http.MethodPut, f.prefix+name, nil, content)
+	if err != nil {
+		return fmt.Errorf("s3 write file %s: %w", name, err)
+	}
+	defer rsp.Body.Close()
+
+	return nil
+}
+
+func (f *S3Filesystem) ReadFile(name string) ([]byte, error) {
+	rsp, err := f.do(http.MethodGet, f.prefix+name, nil, nil)
+	if err != nil {
+		var s3Err *S3Error
+		if errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusNotFound {
+			return nil, ErrFileNotExists
+		}
+
+		return nil, fmt.Errorf("s3 read file %s: %w", name, err)
+	}
+	defer rsp.Body.Close()
+
+	content, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("s3 read file %s: %w", name, err)
+	}
+
+	return content, nil
+}
+
+// Open reads the whole object, the object body can not seek.
+func (f *S3Filesystem) Open(name string) (io.ReadSeekCloser, error) {
+	content, err := f.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newContentReader(content), nil
+}
+
+func (f *S3Filesystem) RemoveFile(name string) error {
+	rsp, err := f.do(http.MethodDelete, f.prefix+name, nil, nil)
+	if err != nil {
+		return fmt.Errorf("s3 remove file %s: %w", name, err)
+	}
+	defer rsp.Body.Close()
+
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         uint64    `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the objects of the bucket under the prefix.
+func (f *S3Filesystem) List() ([]FileInfo, error) {
+	var files []FileInfo
+
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {f.prefix},
+	}
+	for {
+		result, err := f.list(query)
+		if err != nil {
+			return nil, fmt.Errorf("s3 list: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			files = append(files, FileInfo{
+				Name:    strings.TrimPrefix(object.Key, f.prefix),
+				Size:    object.Size,
+				ModTime: object.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (f *S3Filesystem) list(query url.Values) (*listBucketResult, error) {
+	rsp, err := f.do(http.MethodGet, "", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	result := &listBucketResult{}
+	if err := xml.NewDecoder(rsp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	return result, nil
+}
+
+// do sends the signed request for the object key, or for the bucket if the key is empty.
+// The responses other than 2xx are returned as S3Error.
+func (f *S3Filesystem) do(method, key string, query url.Values, content []byte) (*http.Response, error) {
+	target := *f.endpoint
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + f.bucket
+	if key != "" {
+		target.Path += "/" + key
+	}
+	target.RawPath = ""
+	target.RawQuery = query.Encode()
+
+	var body io.Reader
+	if content != nil {
+		body = bytes.NewReader(content)
+	}
+
+	r, err := http.NewRequestWithContext(context.Background(), method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// The path is sent as it is signed.
+	r.URL.RawPath = uriEncode(r.URL.Path, false)
+	f.signer.sign(r, hashHex(content), time.Now())
+
+	rsp, err := f.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		defer rsp.Body.Close()
+
+		s3Err := &S3Error{}
+		_ = xml.NewDecoder(io.LimitReader(rsp.Body, s3ErrorBodySize)).Decode(s3Err)
+		s3Err.StatusCode = rsp.StatusCode
+
+		return nil, s3Err
+	}
+
+	return rsp, nil
+}
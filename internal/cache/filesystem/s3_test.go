@@ -0,0 +1,256 @@
+package filesystem
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	s3TestBucket    = "bucket"
+	s3TestAccessKey = "access"
+	s3TestSecretKey = "secret"
+	s3TestRegion    = "test-region"
+)
+
+// s3Stub is a minimal S3-compatible server keeping the objects of a single bucket in memory.
+// It checks the signatures of the requests.
+type s3Stub struct {
+	mu       sync.Mutex
+	objects  map[string]memoryFile
+	pageSize int
+	signer   *sigV4Signer
+}
+
+func newS3Stub(t *testing.T) (*s3Stub, *httptest.Server) {
+	t.Helper()
+
+	stub := &s3Stub{
+		objects:  make(map[string]memoryFile),
+		pageSize: 2,
+		signer:   &sigV4Signer{accessKey: s3TestAccessKey, secretKey: s3TestSecretKey, region: s3TestRegion},
+	}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	return stub, server
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	if !s.verify(r, content) {
+		s.writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == s3TestBucket && r.Method == http.MethodGet {
+		s.list(w, r.URL.Query())
+		return
+	}
+
+	if !strings.HasPrefix(path, s3TestBucket+"/") {
+		s.writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(path, s3TestBucket+"/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[key] = memoryFile{content: content, modTime: time.Now().Truncate(time.Second)}
+	case http.MethodGet:
+		object, ok := s.objects[key]
+		if !ok {
+			s.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		_, _ = w.Write(object.content)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// verify signs the request again with the headers it claims to be signed.
+func (s *s3Stub) verify(r *http.Request, content []byte) bool {
+	if r.Header.Get(amzContentSHA256Header) != hashHex(content) {
+		return false
+	}
+
+	authorization := r.Header.Get("Authorization")
+	i := strings.Index(authorization, "SignedHeaders=")
+	if i < 0 {
+		return false
+	}
+	signedHeaders := strings.Split(strings.SplitN(authorization[i+len("SignedHeaders="):], ",", 2)[0], ";")
+
+	signed, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil) // nolint:noctx
+	if err != nil {
+		return false
+	}
+	signed.URL.RawPath = r.URL.RawPath
+	for _, name := range signedHeaders {
+		if name != "host" {
+			signed.Header[http.CanonicalHeaderKey(name)] = r.Header.Values(name)
+		}
+	}
+
+	now, err := time.Parse(sigV4DateFormat, r.Header.Get(amzDateHeader))
+	if err != nil {
+		return false
+	}
+	s.signer.sign(signed, r.Header.Get(amzContentSHA256Header), now)
+
+	return signed.Header.Get("Authorization") == authorization
+}
+
+func (s *s3Stub) list(w http.ResponseWriter, query url.Values) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{}
+	if len(keys) > s.pageSize {
+		keys = keys[:s.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key          string    `xml:"Key"`
+			Size         uint64    `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		}{key, uint64(len(s.objects[key].content)), s.objects[key].modTime})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listBucketResult
+	}{listBucketResult: result})
+}
+
+func (s *s3Stub) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: http.StatusText(status)})
+}
+
+func newTestS3Filesystem(t *testing.T, endpoint, prefix string) *S3Filesystem {
+	t.Helper()
+
+	fs, err := NewS3Filesystem(S3Config{
+		Endpoint:  endpoint,
+		Bucket:    s3TestBucket,
+		Region:    s3TestRegion,
+		AccessKey: s3TestAccessKey,
+		SecretKey: s3TestSecretKey,
+		Prefix:    prefix,
+	})
+	require.NoError(t, err)
+
+	return fs
+}
+
+func TestS3Filesystem(t *testing.T) {
+	t.Run("prefix", func(t *testing.T) {
+		stub, server := newS3Stub(t)
+		fs := newTestS3Filesystem(t, server.URL, "cache/")
+
+		require.NoError(t, fs.WriteFile("file.jpg", []byte("content")))
+		require.Contains(t, stub.objects, "cache/file.jpg")
+
+		// The objects out of the prefix are never listed, so the cache never removes them.
+		stub.objects["other/file.jpg"] = memoryFile{content: []byte("other")}
+		stub.objects["photos/cat.jpg"] = memoryFile{content: []byte("cat")}
+		stub.objects["cache.jpg"] = memoryFile{content: []byte("cache")}
+		files, err := fs.List()
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		require.Equal(t, "file.jpg", files[0].Name)
+	})
+
+	t.Run("prefix slash", func(t *testing.T) {
+		stub, server := newS3Stub(t)
+		fs := newTestS3Filesystem(t, server.URL, "cache")
+
+		require.NoError(t, fs.WriteFile("file.jpg", []byte("content")))
+		require.Contains(t, stub.objects, "cache/file.jpg")
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		_, server := newS3Stub(t)
+		fs := newTestS3Filesystem(t, server.URL, "cache/")
+
+		names := []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg"}
+		for _, name := range names {
+			require.NoError(t, fs.WriteFile(name, []byte(name)))
+		}
+
+		files, err := fs.List()
+		require.NoError(t, err)
+		require.Len(t, files, len(names))
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		_, server := newS3Stub(t)
+		fs, err := NewS3Filesystem(S3Config{
+			Endpoint:  server.URL,
+			Bucket:    s3TestBucket,
+			Region:    s3TestRegion,
+			AccessKey: s3TestAccessKey,
+			SecretKey: "wrong",
+			Prefix:    "cache/",
+		})
+		require.NoError(t, err)
+
+		err = fs.WriteFile("file.jpg", []byte("content"))
+		var s3Err *S3Error
+		require.ErrorAs(t, err, &s3Err)
+		require.Equal(t, http.StatusForbidden, s3Err.StatusCode)
+		require.Equal(t, "SignatureDoesNotMatch", s3Err.Code)
+	})
+
+	t.Run("config", func(t *testing.T) {
+		_, err := NewS3Filesystem(S3Config{Endpoint: "not a url", Bucket: "b", Region: "r"})
+		require.ErrorIs(t, err, ErrS3Config)
+
+		_, err = NewS3Filesystem(S3Config{Endpoint: "http://localhost:9000", Region: "r"})
+		require.ErrorIs(t, err, ErrS3Config)
+
+		// Without a prefix the cache would remove the foreign objects of the whole bucket.
+		_, err = NewS3Filesystem(S3Config{Endpoint: "http://localhost:9000", Bucket: "b", Region: "r", Prefix: "/"})
+		require.ErrorIs(t, err, ErrS3Config)
+	})
+}
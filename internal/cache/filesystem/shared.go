@@ -0,0 +1,94 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSharedNotSupported = errors.New("sharing is not supported by the filesystem")
+	ErrLocked             = errors.New("lock is held by another process")
+)
+
+const (
+	lockFileSuffix = ".lock"
+	// Temp files older than this are treated as left behind by crashed writers.
+	staleTempFileAge = time.Minute
+)
+
+// Shared is implemented by the filesystems several processes of the host may use at once.
+type Shared interface {
+	// TryLock takes the exclusive lock of the name without waiting, it returns ErrLocked when another
+	// holder has it. The lock is released by the returned function or by the exit of the process.
+	TryLock(name string) (unlock func() error, err error)
+	// Lock waits for the lock of the name, the shared locks exclude the exclusive ones only.
+	Lock(name string, exclusive bool) (unlock func() error, err error)
+	// Touch marks the file as used now, the modification times tell the recency of the shared files.
+	Touch(name string) error
+}
+
+var _ Shared = (*DiscFilesystem)(nil)
+
+// NewSharedDiskFilesystem creates the filesystem several processes of the host may share. Unlike
+// NewDiskFilesystem, it leaves the temp files of the writes possibly in progress in other processes.
+// It returns ErrSharedNotSupported on the systems without the file locks.
+func NewSharedDiskFilesystem(basePath string) (*DiscFilesystem, error) {
+	if !locksSupported {
+		return nil, fmt.Errorf("new shared filesystem: %w", ErrSharedNotSupported)
+	}
+
+	if err := ensureDir(basePath); err != nil {
+		return nil, fmt.Errorf("new shared filesystem: %w", err)
+	}
+
+	f := &DiscFilesystem{
+		basePath: basePath,
+		shared:   true,
+	}
+
+	if err := f.tidy(); err != nil {
+		return nil, fmt.Errorf("new shared filesystem: %w", err)
+	}
+
+	return f, nil
+}
+
+func (f *DiscFilesystem) Touch(name string) error {
+	path := f.path(name)
+	now := time.Now()
+
+	if err := os.Chtimes(path, now, now); err != nil {
+		if os.IsNotExist(err) {
+			return ErrFileNotExists
+		}
+
+		return fmt.Errorf("filesystem touch file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// lockPath returns the path of the lock file of the name. The lock files are kept in the base path
+// and hidden from the listing.
+func (f *DiscFilesystem) lockPath(name string) string {
+	return filepath.Join(f.basePath, tempFilePrefix+name+lockFileSuffix)
+}
+
+func isLockFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix) && strings.HasSuffix(name, lockFileSuffix)
+}
+
+// isStaleTempFile tells whether the temp file is left by an interrupted write.
+func (f *DiscFilesystem) isStaleTempFile(path string) bool {
+	if !f.shared {
+		return true
+	}
+
+	info, err := os.Stat(path)
+
+	return err == nil && time.Since(info.ModTime()) >= staleTempFileAge
+}
@@ -0,0 +1,17 @@
+package filesystem
+
+import "errors"
+
+var ErrVolumeStatNotSupported = errors.New("volume stat is not supported by the filesystem")
+
+// Volume is implemented by the filesystems stored on a disk volume.
+type Volume interface {
+	Stat() (VolumeStat, error)
+}
+
+type VolumeStat struct {
+	// BlockSize is the allocation unit of the volume, every file takes a multiple of it.
+	BlockSize uint64
+	// Free is the space available to the unprivileged users.
+	Free uint64
+}
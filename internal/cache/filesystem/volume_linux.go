@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package filesystem
+
+import (
+	"fmt"
+	"syscall"
+)
+
+var _ Volume = (*DiscFilesystem)(nil)
+
+func (f *DiscFilesystem) Stat() (VolumeStat, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(f.basePath, &stat); err != nil {
+		return VolumeStat{}, fmt.Errorf("filesystem stat %s: %w", f.basePath, err)
+	}
+
+	blockSize := uint64(stat.Frsize)
+	if blockSize == 0 {
+		blockSize = uint64(stat.Bsize)
+	}
+
+	return VolumeStat{
+		BlockSize: blockSize,
+		Free:      stat.Bavail * blockSize,
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package filesystem
+
+var _ Volume = (*DiscFilesystem)(nil)
+
+func (f *DiscFilesystem) Stat() (VolumeStat, error) {
+	return VolumeStat{}, ErrVolumeStatNotSupported
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pustato/image-previewer/internal/app"
+)
+
+type flightFunc func(ctx context.Context) (*app.Image, error)
+
+// flightGroup coalesces the concurrent calls with the same key, so one call serves all of them.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	img     *app.Image
+	err     error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: make(map[string]*flight),
+	}
+}
+
+// do runs fn once for all the callers of the key coming while it is in progress. A caller leaving
+// on its context does not fail the others: fn gets a context detached from the callers and cancelled
+// only when every caller has left.
+func (g *flightGroup) do(ctx context.Context, key string, fn flightFunc) (*app.Image, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		f = g.start(ctx, key, fn)
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.img, f.err
+	case <-ctx.Done():
+		g.leave(key, f)
+
+		return nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) start(ctx context.Context, key string, fn flightFunc) *flight {
+	flightCtx, cancel := context.WithCancel(detachedContext{ctx})
+	f := &flight{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	g.flights[key] = f
+
+	go func() {
+		defer cancel()
+
+		f.img, f.err = fn(flightCtx)
+
+		g.mu.Lock()
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		g.mu.Unlock()
+
+		close(f.done)
+	}()
+
+	return f
+}
+
+func (g *flightGroup) leave(key string, f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+
+	f.cancel()
+	// The cancelled flight must not be joined by the callers coming later.
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
+
+// detachedContext keeps the values of the parent context but never gets done with it.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
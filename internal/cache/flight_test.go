@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pustato/image-previewer/internal/app"
+	mockapp "github.com/pustato/image-previewer/internal/app/mocks"
+	mockfilesystem "github.com/pustato/image-previewer/internal/cache/filesystem/mocks"
+	mocklru "github.com/pustato/image-previewer/internal/cache/lru/mocks"
+	"github.com/stretchr/testify/require"
+)
+
+const waitFor = time.Second
+
+func waiters(g *flightGroup, key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		return f.waiters
+	}
+
+	return 0
+}
+
+func TestAppCacheDecorator_GetAndResize_Coalescing(t *testing.T) {
+	const callers = 50
+
+	result := app.NewImage([]byte("success result"), time.Now())
+	release := make(chan time.Time)
+
+	cache := &mocklru.Cache{}
+	cache.
+		On("Get", anyCacheKey).
+		Return(nil, false)
+	cache.
+		On("Set", anyCacheKey, anyCacheItem).
+		Once().
+		Return(false)
+
+	appp := &mockapp.App{}
+	appp.
+		On("GetAndResize", anyContext, url, opts, headers).
+		Once().
+		WaitUntil(release).
+		Return(result, nil)
+
+	fs := &mockfilesystem.Filesystem{}
+	fs.
+		On("WriteFile", anyFileName, imageContent(result)).
+		Once().
+		Return(nil)
+	fs.
+		On("WriteFile", anyMetadata, anyContent).
+		Once().
+		Return(nil)
+
+	unit := createApp(appp, cache, fs)
+	key := unit.keys.Key(url, opts, headers)
+
+	results := make([]*app.Image, callers)
+	errs := make([]error, callers)
+	wg := sync.WaitGroup{}
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = unit.GetAndResize(ctx, url, opts, headers)
+		}(i)
+	}
+
+	require.Eventually(t, func() bool {
+		return waiters(unit.flights, key) == callers
+	}, waitFor, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	bodies := make(map[io.ReadSeekCloser]bool, callers)
+	for i := 0; i < callers; i++ {
+		require.NoError(t, errs[i])
+		require.Equal(t, result.ETag, results[i].ETag)
+		require.Equal(t, []byte("success result"), readBody(t, results[i]))
+		bodies[results[i].Body] = true
+	}
+	require.Len(t, bodies, callers, "every caller reads its own body")
+	appp.AssertNumberOfCalls(t, "GetAndResize", 1)
+	fs.AssertNumberOfCalls(t, "WriteFile", 2)
+	cache.AssertNumberOfCalls(t, "Set", 1)
+}
+
+func TestFlightGroup_Do(t *testing.T) {
+	const key = "key"
+
+	t.Run("error is shared", func(t *testing.T) {
+		expectedErr := errors.New("expected error")
+		release := make(chan struct{})
+		calls := 0
+
+		unit := newFlightGroup()
+		fn := func(_ context.Context) (*app.Image, error) {
+			calls++
+			<-release
+
+			return nil, expectedErr
+		}
+
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				_, err := unit.do(ctx, key, fn)
+				errs <- err
+			}()
+		}
+
+		require.Eventually(t, func() bool { return waiters(unit, key) == 2 }, waitFor, time.Millisecond)
+		close(release)
+
+		require.ErrorIs(t, <-errs, expectedErr)
+		require.ErrorIs(t, <-errs, expectedErr)
+		require.Equal(t, 1, calls)
+		require.Empty(t, unit.flights)
+	})
+
+	t.Run("cancelled caller does not fail the others", func(t *testing.T) {
+		result := app.NewImage([]byte("result"), time.Now())
+		release := make(chan struct{})
+		started := make(chan context.Context, 1)
+
+		unit := newFlightGroup()
+		fn := func(ctx context.Context) (*app.Image, error) {
+			started <- ctx
+			<-release
+
+			return result, nil
+		}
+
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancelled := make(chan error, 1)
+		go func() {
+			_, err := unit.do(cancelledCtx, key, fn)
+			cancelled <- err
+		}()
+		flightCtx := <-started
+
+		var img *app.Image
+		var err error
+		done := make(chan struct{})
+		go func() {
+			img, err = unit.do(ctx, key, fn)
+			close(done)
+		}()
+		require.Eventually(t, func() bool { return waiters(unit, key) == 2 }, waitFor, time.Millisecond)
+
+		cancel()
+		require.ErrorIs(t, <-cancelled, context.Canceled)
+		require.NoError(t, flightCtx.Err())
+
+		close(release)
+		<-done
+		require.NoError(t, err)
+		require.Same(t, result, img)
+	})
+
+	t.Run("all callers cancelled", func(t *testing.T) {
+		started := make(chan context.Context, 1)
+
+		unit := newFlightGroup()
+		fn := func(ctx context.Context) (*app.Image, error) {
+			started <- ctx
+			<-ctx.Done()
+
+			return nil, ctx.Err()
+		}
+
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancelled := make(chan error, 1)
+		go func() {
+			_, err := unit.do(cancelledCtx, key, fn)
+			cancelled <- err
+		}()
+		flightCtx := <-started
+
+		cancel()
+		require.ErrorIs(t, <-cancelled, context.Canceled)
+		require.Eventually(t, func() bool { return flightCtx.Err() != nil }, waitFor, time.Millisecond)
+
+		// The cancelled flight is not joined by the later callers.
+		require.Empty(t, unit.flights)
+	})
+
+	t.Run("values are kept", func(t *testing.T) {
+		type ctxKey struct{}
+		valueCtx, cancel := context.WithTimeout(context.WithValue(ctx, ctxKey{}, "value"), time.Hour)
+		defer cancel()
+
+		unit := newFlightGroup()
+		_, err := unit.do(valueCtx, key, func(ctx context.Context) (*app.Image, error) {
+			_, hasDeadline := ctx.Deadline()
+			require.False(t, hasDeadline)
+			require.Equal(t, "value", ctx.Value(ctxKey{}))
+
+			return nil, nil
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/pustato/image-previewer/internal/cache/lru"
+)
+
+// hotTier keeps the content of the most used previews in memory in front of the storage.
+// The storage LRU stays the index of the cache: the tier is only looked up for the indexed
+// previews, and the storage eviction invalidates it.
+type hotTier struct {
+	mu       sync.RWMutex
+	contents map[string]hotContent
+	index    lru.Cache
+	limit    uint64
+}
+
+type hotContent struct {
+	// etag tells the content of a replaced preview from the current one.
+	etag    string
+	content []byte
+}
+
+func newHotTier(limit uint64) *hotTier {
+	t := &hotTier{
+		contents: make(map[string]hotContent),
+		limit:    limit,
+	}
+	t.index = lru.NewCache(limit, t.evict)
+
+	return t
+}
+
+// get returns the content of the preview of the key, if the tier keeps the content of the etag.
+func (t *hotTier) get(key, etag string) ([]byte, bool) {
+	if _, ok := t.index.Get(key); !ok {
+		return nil, false
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	c, ok := t.contents[key]
+	if !ok || c.etag != etag {
+		return nil, false
+	}
+
+	return c.content, true
+}
+
+// set promotes the content to the tier, the content larger than the whole tier is skipped.
+func (t *hotTier) set(key, etag string, content []byte) {
+	size := uint64(len(content))
+	if size > t.limit {
+		return
+	}
+
+	t.mu.Lock()
+	t.contents[key] = hotContent{etag: etag, content: content}
+	t.mu.Unlock()
+
+	t.index.Set(key, &lru.Item{FileName: key, Size: size, ETag: etag})
+}
+
+func (t *hotTier) remove(key string) {
+	if !t.index.Remove(key) {
+		// The content may be set while its index item is not yet.
+		t.mu.Lock()
+		delete(t.contents, key)
+		t.mu.Unlock()
+	}
+}
+
+func (t *hotTier) evict(item *lru.Item) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// A concurrent set may have replaced the content already.
+	if c, ok := t.contents[item.Key()]; ok && c.etag == item.ETag {
+		delete(t.contents, item.Key())
+	}
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pustato/image-previewer/internal/app"
+	mockapp "github.com/pustato/image-previewer/internal/app/mocks"
+	"github.com/pustato/image-previewer/internal/cache/filesystem"
+	"github.com/pustato/image-previewer/internal/resizer"
+	"github.com/stretchr/testify/require"
+)
+
+// readCounter counts the reads of the storage.
+type readCounter struct {
+	filesystem.Filesystem
+	mu    sync.Mutex
+	reads int
+}
+
+func (r *readCounter) ReadFile(name string) ([]byte, error) {
+	r.mu.Lock()
+	r.reads++
+	r.mu.Unlock()
+
+	return r.Filesystem.ReadFile(name)
+}
+
+func TestHotTier(t *testing.T) {
+	tier := newHotTier(20)
+
+	_, ok := tier.get("key1", "etag1")
+	require.False(t, ok)
+
+	tier.set("key1", "etag1", []byte("0123456789"))
+	content, ok := tier.get("key1", "etag1")
+	require.True(t, ok)
+	require.Equal(t, []byte("0123456789"), content)
+
+	_, ok = tier.get("key1", "etag2")
+	require.False(t, ok, "content of a replaced preview")
+
+	tier.set("key2", "etag2", []byte("0123456789"))
+	tier.get("key1", "etag1")
+	tier.set("key3", "etag3", []byte("0123456789"))
+	_, ok = tier.get("key2", "etag2")
+	require.False(t, ok, "least recently used evicted")
+	_, ok = tier.get("key1", "etag1")
+	require.True(t, ok)
+
+	tier.set("large", "etag", []byte("012345678901234567890"))
+	_, ok = tier.get("large", "etag")
+	require.False(t, ok, "larger than the tier")
+	_, ok = tier.get("key3", "etag3")
+	require.True(t, ok)
+
+	tier.remove("key1")
+	_, ok = tier.get("key1", "etag1")
+	require.False(t, ok)
+
+	require.Len(t, tier.contents, 1)
+}
+
+func TestHotTier_Concurrent(t *testing.T) {
+	tier := newHotTier(100)
+
+	wg := &sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa((g + i) % 16)
+				switch i % 3 {
+				case 0:
+					tier.set(key, key, []byte(key+"0123456789"))
+				case 1:
+					if content, ok := tier.get(key, key); ok && string(content) != key+"0123456789" {
+						panic("content of another key: " + string(content))
+					}
+				default:
+					tier.remove(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	tier.mu.RLock()
+	defer tier.mu.RUnlock()
+	for _, item := range tier.index.Items() {
+		require.Contains(t, tier.contents, item.Key())
+	}
+}
+
+func TestAppCacheDecorator_GetAndResize_HotTier(t *testing.T) {
+	newImage := func(s string) *app.Image {
+		img := app.NewImage([]byte(s), time.Now())
+		img.Header = http.Header{}
+
+		return img
+	}
+	optsOf := func(width int) resizer.Options {
+		o := opts
+		o.Width = width
+
+		return o
+	}
+
+	appp := &mockapp.App{}
+	for width := 1; width <= 4; width++ {
+		appp.
+			On("GetAndResize", anyContext, url, optsOf(width), headers).
+			Once().
+			Return(newImage("preview-"+strconv.Itoa(width)), nil)
+	}
+
+	fs := &readCounter{Filesystem: filesystem.NewMemoryFilesystem()}
+	unit, err := NewCacheAppDecorator(appp, Limits{Entries: 2, MemorySize: 9}, fs)
+	require.NoError(t, err)
+
+	get := func(width int) {
+		t.Helper()
+
+		img, err := unit.GetAndResize(ctx, url, optsOf(width), headers)
+		require.NoError(t, err)
+		require.Equal(t, "preview-"+strconv.Itoa(width), string(readBody(t, img)))
+	}
+
+	get(1)
+	require.Equal(t, Stats{Memory: TierStats{Misses: 1}, Storage: TierStats{Misses: 1}}, unit.Stats())
+
+	get(1)
+	require.Equal(t, 1, fs.reads, "promoted on the storage hit")
+	get(1)
+	require.Equal(t, 1, fs.reads, "served from memory")
+	require.Equal(t, Stats{Memory: TierStats{Hits: 1, Misses: 2}, Storage: TierStats{Hits: 1, Misses: 1}}, unit.Stats())
+
+	get(2)
+	get(2)
+	require.Equal(t, 2, fs.reads)
+	require.Len(t, unit.hot.contents, 1, "the tier keeps one preview")
+
+	get(1)
+	require.Equal(t, 3, fs.reads, "evicted from the tier, kept by the storage")
+
+	// The storage evicts the least recently used preview 2, the tier keeps the preview 1 only.
+	get(3)
+	require.Len(t, unit.cache.Items(), 2)
+	_, ok := unit.hot.get(unit.keys.Key(url, optsOf(1), headers), newImage("preview-1").ETag)
+	require.True(t, ok)
+
+	// The storage evicts the preview 1 and invalidates its content in the tier.
+	get(4)
+	require.Empty(t, unit.hot.contents)
+
+	// The preview larger than the tier is streamed from the storage.
+	appp.
+		On("GetAndResize", anyContext, url, optsOf(5), headers).
+		Once().
+		Return(newImage("preview-large"), nil)
+	for i := 0; i < 2; i++ {
+		img, err := unit.GetAndResize(ctx, url, optsOf(5), headers)
+		require.NoError(t, err)
+		require.Equal(t, "preview-large", string(readBody(t, img)))
+	}
+	require.Equal(t, 3, fs.reads)
+	require.Empty(t, unit.hot.contents)
+	appp.AssertExpectations(t)
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+const defaultJanitorInterval = time.Minute
+
+// WithJanitorInterval sets how often the expired previews are removed.
+func (a *AppCacheDecorator) WithJanitorInterval(interval time.Duration) *AppCacheDecorator {
+	a.janitorInterval = interval
+
+	return a
+}
+
+// Run removes the expired previews until the ctx is done, the hits expire them lazily in between.
+// When the storage is shared, it also takes part in the election of the leader among the processes
+// sharing it and evicts the previews of all of them while the process is the leader.
+func (a *AppCacheDecorator) Run(ctx context.Context) {
+	janitor := time.NewTicker(a.janitorInterval)
+	defer janitor.Stop()
+
+	var sweeps <-chan time.Time
+	if a.shared != nil {
+		defer a.shared.resign()
+
+		ticker := time.NewTicker(a.shared.sweepInterval)
+		defer ticker.Stop()
+		sweeps = ticker.C
+
+		a.runShared()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-janitor.C:
+			a.cache.RemoveExpired(now)
+		case <-sweeps:
+			a.runShared()
+		}
+	}
+}
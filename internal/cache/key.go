@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"image/color"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pustato/image-previewer/internal/resizer"
+)
+
+var _ KeyGenerator = (*HashKeyGenerator)(nil)
+
+// KeyGenerator derives the cache key of a preview request.
+type KeyGenerator interface {
+	Key(url string, opts resizer.Options, headers http.Header) string
+	// Varies reports whether the keys differ by the value of the request header.
+	Varies(header string) bool
+}
+
+// HashKeyGenerator hashes every part of the request prefixed with its length, so different requests
+// never share an encoding. The values of the configured request headers are a part of the key.
+type HashKeyGenerator struct {
+	headers []string
+}
+
+func NewHashKeyGenerator(headers []string) *HashKeyGenerator {
+	canonical := make([]string, 0, len(headers))
+	for _, h := range headers {
+		canonical = append(canonical, http.CanonicalHeaderKey(h))
+	}
+	sort.Strings(canonical)
+
+	return &HashKeyGenerator{
+		headers: canonical,
+	}
+}
+
+func (g *HashKeyGenerator) Key(url string, opts resizer.Options, headers http.Header) string {
+	opts = keyOptions(opts)
+	h := sha256.New()
+
+	writeField(h, url)
+	writeField(h, string(opts.Operation))
+	writeField(h, strconv.Itoa(opts.Width))
+	writeField(h, strconv.Itoa(opts.Height))
+	writeField(h, string([]byte{opts.Background.R, opts.Background.G, opts.Background.B, opts.Background.A}))
+	writeField(h, string(opts.Format))
+	writeField(h, strconv.Itoa(opts.Quality))
+	writeField(h, strconv.FormatBool(opts.Optimize))
+
+	for _, key := range g.headers {
+		values := headers.Values(key)
+
+		writeField(h, key)
+		writeField(h, strconv.Itoa(len(values)))
+		for _, v := range values {
+			writeField(h, v)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (g *HashKeyGenerator) Varies(header string) bool {
+	header = http.CanonicalHeaderKey(header)
+
+	for _, h := range g.headers {
+		if h == header {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keyOptions resets the options the preview does not depend on, so requests that differ only by them share a key.
+func keyOptions(opts resizer.Options) resizer.Options {
+	if opts.Operation != resizer.OperationFit {
+		opts.Background = color.NRGBA{}
+	}
+	if !opts.Format.Lossy() {
+		opts.Quality = 0
+	}
+
+	return opts
+}
+
+func writeField(h hash.Hash, field string) {
+	var size [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(size[:], uint64(len(field)))
+	h.Write(size[:n])
+	h.Write([]byte(field))
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"image/color"
+	"net/http"
+	"testing"
+
+	"github.com/pustato/image-previewer/internal/resizer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashKeyGenerator_Key(t *testing.T) {
+	unit := NewHashKeyGenerator(nil)
+
+	fill := unit.Key(url, opts, headers)
+	require.Equal(t, fill, unit.Key(url, opts, headers))
+	require.Regexp(t, "^[0-9a-f]{64}$", fill)
+
+	changes := []func(o *resizer.Options){
+		func(o *resizer.Options) { o.Operation = resizer.OperationFit },
+		func(o *resizer.Options) { o.Operation = resizer.OperationCrop },
+		func(o *resizer.Options) { o.Operation = resizer.OperationResize },
+		func(o *resizer.Options) { o.Width = 101 },
+		func(o *resizer.Options) { o.Height = 0 },
+		func(o *resizer.Options) {
+			o.Operation = resizer.OperationFit
+			o.Background = color.NRGBA{R: 0xff, A: 0xff}
+		},
+		func(o *resizer.Options) { o.Format = resizer.FormatPNG },
+		func(o *resizer.Options) { o.Quality = 90 },
+		func(o *resizer.Options) { o.Optimize = true },
+	}
+
+	for _, change := range changes {
+		o := opts
+		change(&o)
+
+		require.NotEqual(t, fill, unit.Key(url, o, headers))
+	}
+
+	unaffected := []func(o *resizer.Options){
+		func(o *resizer.Options) { o.Background = color.NRGBA{R: 0xff, A: 0xff} },
+		func(o *resizer.Options) {
+			o.Format = resizer.FormatPNG
+			o.Quality = 90
+		},
+	}
+
+	for _, change := range unaffected {
+		o := opts
+		change(&o)
+		base := opts
+		base.Format = o.Format
+
+		require.Equal(t, unit.Key(url, base, headers), unit.Key(url, o, headers))
+	}
+
+	require.NotEqual(t, fill, unit.Key(url+"x", opts, headers))
+	require.Equal(t, fill, unit.Key(url, opts, http.Header{"Accept-Language": {"ru"}}))
+}
+
+func TestHashKeyGenerator_Key_Unambiguous(t *testing.T) {
+	unit := NewHashKeyGenerator(nil)
+
+	a, b := opts, opts
+	a.Width, a.Height = 12, 3
+	b.Width, b.Height = 1, 23
+	require.NotEqual(t, unit.Key(url, a, headers), unit.Key(url, b, headers))
+
+	a.Width, a.Height = 100, 100
+	require.NotEqual(t, unit.Key(url+"1", a, headers), unit.Key(url, b, headers))
+}
+
+func TestHashKeyGenerator_Key_Headers(t *testing.T) {
+	unit := NewHashKeyGenerator([]string{"accept-language", "Authorization"})
+
+	ru := unit.Key(url, opts, http.Header{"Accept-Language": {"ru"}})
+	en := unit.Key(url, opts, http.Header{"Accept-Language": {"en"}})
+	none := unit.Key(url, opts, http.Header{})
+
+	require.NotEqual(t, ru, en)
+	require.NotEqual(t, ru, none)
+	require.Equal(t, ru, unit.Key(url, opts, http.Header{"Accept-Language": {"ru"}, "X-Other": {"1"}}))
+
+	require.NotEqual(t,
+		unit.Key(url, opts, http.Header{"Accept-Language": {"ru", "en"}}),
+		unit.Key(url, opts, http.Header{"Accept-Language": {"ru, en"}}),
+	)
+	require.NotEqual(t,
+		unit.Key(url, opts, http.Header{"Accept-Language": {"a"}}),
+		unit.Key(url, opts, http.Header{"Authorization": {"a"}}),
+	)
+
+	require.True(t, unit.Varies("Accept-Language"))
+	require.True(t, unit.Varies("authorization"))
+	require.False(t, unit.Varies("Cookie"))
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pustato/image-previewer/internal/cache/lru"
+)
+
+var (
+	ErrNoLimit             = errors.New("neither size nor entries limit is set")
+	ErrNegativeEntries     = errors.New("negative entries limit")
+	ErrInvalidLowWatermark = errors.New("low watermark must be in (0, 1]")
+	ErrNegativeTTL         = errors.New("negative ttl")
+)
+
+// Limits bound the cache, their zero values mean no limit.
+type Limits struct {
+	// Size is the total size of the cached previews and their metadata in bytes.
+	Size uint64
+	// Entries is the number of the cached previews.
+	Entries int
+	// ItemSize is the size of the largest preview to cache along with its metadata, the larger ones are
+	// served without caching. It never exceeds the low watermark of Size, so a single preview never flushes
+	// the cache.
+	ItemSize uint64
+	// LowWatermark is the share of Size and Entries the eviction frees the cache down to, 0 means 1.
+	LowWatermark float64
+	// BlockAccounting makes Size account the previews by the blocks they take on the cache volume
+	// rather than by their length, so many small previews do not take more space than Size.
+	BlockAccounting bool
+	// MinFreeSpace is the free space the eviction keeps on the cache volume.
+	MinFreeSpace uint64
+	// TTL is the lifetime of the previews the source does not limit with the Cache-Control max-age
+	// or the Expires header.
+	TTL time.Duration
+	// MemorySize is the total size of the hottest previews kept in memory in front of the storage,
+	// 0 disables the in-memory tier.
+	MemorySize uint64
+}
+
+func (l Limits) Validate() error {
+	if l.Entries < 0 {
+		return fmt.Errorf("limits %d: %w", l.Entries, ErrNegativeEntries)
+	}
+
+	if l.Size == 0 && l.Entries == 0 {
+		return fmt.Errorf("limits: %w", ErrNoLimit)
+	}
+
+	if l.LowWatermark < 0 || l.LowWatermark > 1 {
+		return fmt.Errorf("limits %v: %w", l.LowWatermark, ErrInvalidLowWatermark)
+	}
+
+	if l.TTL < 0 {
+		return fmt.Errorf("limits %s: %w", l.TTL, ErrNegativeTTL)
+	}
+
+	return nil
+}
+
+// maxItemSize returns the size of the largest preview to cache, 0 means any. It never exceeds the share
+// of Size the eviction frees the cache down to, or a preview inserted into the full cache would evict
+// every other preview and then itself.
+func (l Limits) maxItemSize() uint64 {
+	if l.Size == 0 {
+		return l.ItemSize
+	}
+
+	low := uint64(float64(l.Size) * l.lowWatermark())
+	if l.ItemSize == 0 || l.ItemSize > low {
+		return low
+	}
+
+	return l.ItemSize
+}
+
+func (l Limits) lowWatermark() float64 {
+	if l.LowWatermark == 0 {
+		return 1
+	}
+
+	return l.LowWatermark
+}
+
+// newIndex creates the LRU evicting the previews beyond the limits.
+func (l Limits) newIndex(blockSize uint64, onRemove lru.RemoveItemCallback) *lru.CacheLRU {
+	return lru.NewCache(l.Size, onRemove).
+		WithMaxEntries(l.Entries).
+		WithLowWatermark(l.lowWatermark()).
+		WithBlockSize(blockSize)
+}
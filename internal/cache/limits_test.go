@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits_Validate(t *testing.T) {
+	testData := []struct {
+		name   string
+		limits Limits
+		err    error
+	}{
+		{"size", Limits{Size: 100}, nil},
+		{"entries", Limits{Entries: 10}, nil},
+		{"combined", Limits{Size: 100, Entries: 10, ItemSize: 10, LowWatermark: 0.9}, nil},
+		{"no limit", Limits{ItemSize: 10}, ErrNoLimit},
+		{"negative entries", Limits{Size: 100, Entries: -1}, ErrNegativeEntries},
+		{"negative low watermark", Limits{Size: 100, LowWatermark: -0.1}, ErrInvalidLowWatermark},
+		{"low watermark above 1", Limits{Size: 100, LowWatermark: 1.1}, ErrInvalidLowWatermark},
+		{"ttl", Limits{Size: 100, TTL: time.Hour}, nil},
+		{"negative ttl", Limits{Size: 100, TTL: -time.Second}, ErrNegativeTTL},
+	}
+
+	for _, td := range testData {
+		td := td
+		t.Run(td.name, func(t *testing.T) {
+			err := td.limits.Validate()
+			if td.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, td.err)
+			}
+		})
+	}
+}
+
+func TestLimits_MaxItemSize(t *testing.T) {
+	require.EqualValues(t, 100, Limits{Size: 100}.maxItemSize())
+	require.EqualValues(t, 10, Limits{Size: 100, ItemSize: 10}.maxItemSize())
+	require.EqualValues(t, 100, Limits{Size: 100, ItemSize: 1000}.maxItemSize())
+	require.EqualValues(t, 10, Limits{Entries: 10, ItemSize: 10}.maxItemSize())
+	require.EqualValues(t, 0, Limits{Entries: 10}.maxItemSize())
+	require.EqualValues(t, 80, Limits{Size: 100, LowWatermark: 0.8}.maxItemSize())
+	require.EqualValues(t, 80, Limits{Size: 100, ItemSize: 90, LowWatermark: 0.8}.maxItemSize())
+	require.EqualValues(t, 50, Limits{Size: 100, ItemSize: 50, LowWatermark: 0.8}.maxItemSize())
+	require.EqualValues(t, 10, Limits{Entries: 10, ItemSize: 10, LowWatermark: 0.5}.maxItemSize())
+}
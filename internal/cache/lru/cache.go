@@ -2,22 +2,63 @@ package lru
 
 import (
 	"container/list"
+	"net/http"
 	"sync"
+	"time"
 )
 
 var _ Cache = (*CacheLRU)(nil)
 
 type Cache interface {
+	// Get returns the item of the key, the expired item is removed and missed.
 	Get(key string) (*Item, bool)
 	Set(key string, item *Item) bool
+	// Remove evicts the item of the key, it returns false when there is no such item.
+	Remove(key string) bool
+	// RemoveOldest evicts the least recently used item, it returns false when the cache is empty.
+	RemoveOldest() bool
+	// RemoveExpired removes the items expired by now, it returns the number of the removed items.
+	RemoveExpired(now time.Time) int
+	// Items returns the cached items from the most to the least recently used.
+	Items() []*Item
 }
 
+// Item describes a cached preview, it is stored next to the preview content.
 type Item struct {
-	key      string
-	FileName string
-	Size     uint64
+	key         string
+	FileName    string      `json:"fileName"`
+	Size        uint64      `json:"size"`
+	URL         string      `json:"url"`
+	Operation   string      `json:"operation"`
+	Width       int         `json:"width"`
+	Height      int         `json:"height"`
+	ContentType string      `json:"contentType"`
+	ETag        string      `json:"etag"`
+	ModTime     time.Time   `json:"modTime"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	Header      http.Header `json:"header,omitempty"`
+	// ExpiresAt is the time the item expires at, zero means it never does.
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	// MetadataSize is the size of the metadata stored next to the content, it is accounted along with it.
+	MetadataSize uint64 `json:"-"`
 }
 
+func (i *Item) Key() string {
+	return i.key
+}
+
+// DiskSize returns the size the content and the metadata of the item take on a disk, each of them
+// rounded up to the whole blocks. The block size up to 1 means no rounding.
+func (i *Item) DiskSize(blockSize uint64) uint64 {
+	return roundUp(i.Size, blockSize) + roundUp(i.MetadataSize, blockSize)
+}
+
+func (i *Item) Expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt)
+}
+
+// RemoveItemCallback is called for the removed items once the cache is unlocked, so it may be called
+// concurrently and after the key is set again.
 type RemoveItemCallback func(item *Item)
 
 type CacheLRU struct {
@@ -25,8 +66,13 @@ type CacheLRU struct {
 	list         *list.List
 	items        map[string]*list.Element
 	limit        uint64
+	maxEntries   int
+	lowWatermark float64
+	blockSize    uint64
 	size         uint64
 	onRemoveFunc RemoveItemCallback
+	// removed holds the items removed under the lock until it is released.
+	removed []*Item
 }
 
 func NewCache(limit uint64, onRemove RemoveItemCallback) *CacheLRU {
@@ -34,46 +80,157 @@ func NewCache(limit uint64, onRemove RemoveItemCallback) *CacheLRU {
 		list:         list.New(),
 		items:        make(map[string]*list.Element),
 		limit:        limit,
+		lowWatermark: 1,
 		onRemoveFunc: onRemove,
 	}
 }
 
+// WithMaxEntries limits the number of the items in addition to their size. Zero means no limit,
+// as does the zero size limit.
+func (c *CacheLRU) WithMaxEntries(maxEntries int) *CacheLRU {
+	c.maxEntries = maxEntries
+
+	return c
+}
+
+// WithLowWatermark sets the share of the limits the eviction frees the cache down to once they
+// are exceeded, so the cache is not purged on every insert when it is full.
+func (c *CacheLRU) WithLowWatermark(lowWatermark float64) *CacheLRU {
+	c.lowWatermark = lowWatermark
+
+	return c
+}
+
+// WithBlockSize makes the size limit account the items by the blocks they take on a disk.
+func (c *CacheLRU) WithBlockSize(blockSize uint64) *CacheLRU {
+	c.blockSize = blockSize
+
+	return c
+}
+
 func (c *CacheLRU) Get(key string) (*Item, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	// A hit moves the item to the front, so even the reads modify the list.
+	c.mu.Lock()
+	defer c.unlock()
 
 	element, ok := c.items[key]
 	if !ok {
 		return nil, false
 	}
 
+	item := element.Value.(*Item)
+	if item.Expired(time.Now()) {
+		c.remove(key)
+
+		return nil, false
+	}
+
 	c.list.MoveToFront(element)
 
-	return element.Value.(*Item), true
+	return item, true
 }
 
 func (c *CacheLRU) Set(key string, item *Item) bool {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
 	item.key = key
 
 	if element, ok := c.items[key]; ok {
+		replaced := element.Value.(*Item)
 		element.Value = item
 		c.list.MoveToFront(element)
+		c.size += c.itemSize(item)
+		c.size -= c.itemSize(replaced)
+
+		// The file of the same name is overwritten by the new item already.
+		if replaced.FileName != item.FileName {
+			c.removed = append(c.removed, replaced)
+		}
+
+		c.gc()
 
 		return true
 	}
 
 	element := c.list.PushFront(item)
 	c.items[key] = element
-	c.size += item.Size
+	c.size += c.itemSize(item)
 
 	c.gc()
 
 	return false
 }
 
+func (c *CacheLRU) Items() []*Item {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	items := make([]*Item, 0, c.list.Len())
+	for element := c.list.Front(); element != nil; element = element.Next() {
+		items = append(items, element.Value.(*Item))
+	}
+
+	return items
+}
+
+func (c *CacheLRU) Remove(key string) bool {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if _, ok := c.items[key]; !ok {
+		return false
+	}
+
+	c.remove(key)
+
+	return true
+}
+
+func (c *CacheLRU) RemoveOldest() bool {
+	c.mu.Lock()
+	defer c.unlock()
+
+	element := c.list.Back()
+	if element == nil {
+		return false
+	}
+
+	c.remove(element.Value.(*Item).key)
+
+	return true
+}
+
+func (c *CacheLRU) RemoveExpired(now time.Time) int {
+	c.mu.Lock()
+	defer c.unlock()
+
+	removed := 0
+	for element := c.list.Front(); element != nil; {
+		item := element.Value.(*Item)
+		element = element.Next()
+
+		if item.Expired(now) {
+			c.remove(item.key)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+func (c *CacheLRU) itemSize(item *Item) uint64 {
+	return item.DiskSize(c.blockSize)
+}
+
+func roundUp(size, blockSize uint64) uint64 {
+	if blockSize <= 1 {
+		return size
+	}
+
+	return (size + blockSize - 1) / blockSize * blockSize
+}
+
 func (c *CacheLRU) remove(key string) {
 	element, ok := c.items[key]
 	if !ok {
@@ -83,15 +240,41 @@ func (c *CacheLRU) remove(key string) {
 	delete(c.items, key)
 	item := element.Value.(*Item)
 	c.list.Remove(element)
-	c.size -= item.Size
+	c.size -= c.itemSize(item)
 
-	c.onRemoveFunc(item)
+	c.removed = append(c.removed, item)
+}
+
+// unlock releases the lock and then calls the callback for the items removed under it, so the removals
+// of their files do not block the other calls.
+func (c *CacheLRU) unlock() {
+	removed := c.removed
+	c.removed = nil
+	c.mu.Unlock()
+
+	for _, item := range removed {
+		c.onRemoveFunc(item)
+	}
 }
 
 func (c *CacheLRU) gc() {
-	for c.size > c.limit {
+	if !c.exceeds(c.limit, c.maxEntries) {
+		return
+	}
+
+	size := uint64(float64(c.limit) * c.lowWatermark)
+	// The item just set is kept whatever the low watermark of the entries is.
+	entries := int(float64(c.maxEntries) * c.lowWatermark)
+	if entries < 1 {
+		entries = 1
+	}
+	for c.list.Len() > 0 && c.exceeds(size, entries) {
 		element := c.list.Back()
 		item := element.Value.(*Item)
 		c.remove(item.key)
 	}
 }
+
+func (c *CacheLRU) exceeds(size uint64, entries int) bool {
+	return (c.limit > 0 && c.size > size) || (c.maxEntries > 0 && c.list.Len() > entries)
+}
@@ -1,10 +1,13 @@
 package lru
 
 import (
+	"fmt"
 	"math/rand"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -85,6 +88,80 @@ func TestCache_Purge(t *testing.T) {
 	require.False(t, hit)
 }
 
+func TestCache_Replace(t *testing.T) {
+	testData := []struct {
+		name     string
+		replaced *Item
+		item     *Item
+		size     uint64
+		removed  []string
+		evicted  bool
+	}{
+		{
+			name:     "same item",
+			replaced: &Item{FileName: "key.jpg", Size: 10},
+			size:     30,
+		},
+		{
+			name:     "grows",
+			replaced: &Item{FileName: "key.jpg", Size: 10},
+			item:     &Item{FileName: "key.jpg", Size: 15},
+			size:     35,
+		},
+		{
+			name:     "shrinks",
+			replaced: &Item{FileName: "key.jpg", Size: 10},
+			item:     &Item{FileName: "key.jpg", Size: 5},
+			size:     25,
+		},
+		{
+			name:     "other file",
+			replaced: &Item{FileName: "key.jpg", Size: 10},
+			item:     &Item{FileName: "key.png", Size: 10},
+			size:     30,
+			removed:  []string{"key.jpg"},
+		},
+		{
+			name:     "exceeds limit",
+			replaced: &Item{FileName: "key.jpg", Size: 10},
+			item:     &Item{FileName: "key.jpg", Size: 40},
+			size:     50,
+			removed:  []string{"old.jpg"},
+			evicted:  true,
+		},
+	}
+
+	for _, td := range testData {
+		td := td
+		t.Run(td.name, func(t *testing.T) {
+			var removed []string
+			c := NewCache(50, func(item *Item) {
+				removed = append(removed, item.FileName)
+			})
+
+			c.Set("old", &Item{FileName: "old.jpg", Size: 10})
+			c.Set("key", td.replaced)
+			c.Set("new", &Item{FileName: "new.jpg", Size: 10})
+
+			item := td.item
+			if item == nil {
+				item = td.replaced
+			}
+			require.True(t, c.Set("key", item))
+
+			require.Equal(t, td.size, c.size)
+			require.Equal(t, td.removed, removed)
+
+			actual, ok := c.Get("key")
+			require.True(t, ok)
+			require.Same(t, item, actual)
+
+			_, ok = c.Get("old")
+			require.Equal(t, !td.evicted, ok)
+		})
+	}
+}
+
 func TestCache_Multithreading(t *testing.T) {
 	iterationsCount := 100_000
 	c := NewCache(10000, func(item *Item) {
@@ -111,3 +188,255 @@ func TestCache_Multithreading(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestCache_ConcurrentStress(t *testing.T) {
+	const (
+		goroutines    = 16
+		iterations    = 10_000
+		keys          = 64
+		limit         = 32 * 10
+		itemSize      = 10
+		setPercentage = 30
+	)
+
+	removed := uint64(0)
+	c := NewCache(limit, func(item *Item) {
+		atomic.AddUint64(&removed, 1)
+	})
+
+	wg := &sync.WaitGroup{}
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(seed int64) {
+			defer wg.Done()
+
+			rnd := rand.New(rand.NewSource(seed)) // nolint:gosec
+			for i := 0; i < iterations; i++ {
+				key := strconv.Itoa(rnd.Intn(keys))
+				if rnd.Intn(100) < setPercentage {
+					c.Set(key, newItemStub(itemSize))
+				} else if item, ok := c.Get(key); ok {
+					require.Equal(t, key, item.Key())
+				}
+				if i%1000 == 0 {
+					c.Items()
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	require.Equal(t, len(c.items), c.list.Len())
+	require.LessOrEqual(t, c.size, uint64(limit))
+
+	size := uint64(0)
+	for _, item := range c.Items() {
+		element, ok := c.items[item.key]
+		require.True(t, ok)
+		require.Same(t, item, element.Value)
+		size += item.Size
+	}
+	require.Equal(t, size, c.size)
+	require.NotZero(t, removed)
+}
+
+func TestCache_RemoveUnlocked(t *testing.T) {
+	var (
+		c     *CacheLRU
+		items []*Item
+	)
+	c = NewCache(10, func(item *Item) {
+		// The callback would deadlock under the lock.
+		items = c.Items()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Set("a", newItemStub(10))
+		c.Set("b", newItemStub(10))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the callback is called under the lock")
+	}
+	require.Len(t, items, 1)
+	require.Equal(t, "b", items[0].Key())
+}
+
+func TestCache_Items(t *testing.T) {
+	c := NewCache(100, func(item *Item) {})
+	require.Empty(t, c.Items())
+
+	item1, item2, item3 := newItemStub(10), newItemStub(10), newItemStub(10)
+	c.Set("key1", item1)
+	c.Set("key2", item2)
+	c.Set("key3", item3)
+	c.Get("key1")
+
+	require.Equal(t, []*Item{item1, item3, item2}, c.Items())
+	require.Equal(t, "key1", c.Items()[0].Key())
+}
+
+func TestCache_Limits(t *testing.T) {
+	testData := []struct {
+		name         string
+		limit        uint64
+		maxEntries   int
+		lowWatermark float64
+		sets         int
+		remaining    []string
+	}{
+		{name: "size", limit: 30, sets: 5, remaining: []string{"4", "3", "2"}},
+		{name: "entries", maxEntries: 2, sets: 5, remaining: []string{"4", "3"}},
+		{name: "size is stricter", limit: 20, maxEntries: 3, sets: 5, remaining: []string{"4", "3"}},
+		{name: "entries are stricter", limit: 100, maxEntries: 1, sets: 5, remaining: []string{"4"}},
+		{name: "within limits", limit: 100, maxEntries: 10, sets: 5, remaining: []string{"4", "3", "2", "1", "0"}},
+		{name: "no limits", sets: 3, remaining: []string{"2", "1", "0"}},
+		{
+			name: "size low watermark", limit: 50, lowWatermark: 0.6, sets: 6,
+			remaining: []string{"5", "4", "3"},
+		},
+		{
+			name: "entries low watermark", maxEntries: 10, lowWatermark: 0.5, sets: 12,
+			remaining: []string{"11", "10", "9", "8", "7", "6"},
+		},
+		{
+			name: "no eviction below the limit", maxEntries: 4, lowWatermark: 0.5, sets: 7,
+			remaining: []string{"6", "5", "4", "3"},
+		},
+		{
+			name: "entries low watermark keeps the item set", maxEntries: 1, lowWatermark: 0.5, sets: 3,
+			remaining: []string{"2"},
+		},
+	}
+
+	for _, td := range testData {
+		td := td
+		t.Run(td.name, func(t *testing.T) {
+			c := NewCache(td.limit, func(item *Item) {}).WithMaxEntries(td.maxEntries)
+			if td.lowWatermark > 0 {
+				c.WithLowWatermark(td.lowWatermark)
+			}
+
+			for i := 0; i < td.sets; i++ {
+				c.Set(strconv.Itoa(i), newItemStub(10))
+			}
+
+			keys := make([]string, 0, len(td.remaining))
+			for _, item := range c.Items() {
+				keys = append(keys, item.Key())
+			}
+			require.Equal(t, td.remaining, keys)
+		})
+	}
+}
+
+func TestCache_BlockSize(t *testing.T) {
+	testData := []struct {
+		blockSize uint64
+		sizes     []uint64
+		metadata  uint64
+		size      uint64
+		remaining int
+	}{
+		{blockSize: 0, sizes: []uint64{1, 10, 100}, size: 111, remaining: 3},
+		{blockSize: 1, sizes: []uint64{1, 10, 100}, size: 111, remaining: 3},
+		{blockSize: 64, sizes: []uint64{1, 64, 65}, size: 256, remaining: 3},
+		{blockSize: 64, sizes: []uint64{0, 1}, size: 64, remaining: 2},
+		{blockSize: 128, sizes: []uint64{1, 1, 1}, size: 256, remaining: 2},
+		{blockSize: 0, sizes: []uint64{1, 10, 100}, metadata: 50, size: 210, remaining: 2},
+		{blockSize: 64, sizes: []uint64{1, 1}, metadata: 1, size: 256, remaining: 2},
+		{blockSize: 64, sizes: []uint64{1, 1, 1}, metadata: 64, size: 256, remaining: 2},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			c := NewCache(256, func(item *Item) {}).WithBlockSize(td.blockSize)
+			for i, size := range td.sizes {
+				item := newItemStub(size)
+				item.MetadataSize = td.metadata
+				c.Set(strconv.Itoa(i), item)
+			}
+
+			require.Equal(t, td.size, c.size)
+			require.Len(t, c.Items(), td.remaining)
+		})
+	}
+}
+
+func TestCache_RemoveOldest(t *testing.T) {
+	var removed []string
+	c := NewCache(100, func(item *Item) {
+		removed = append(removed, item.key)
+	})
+	require.False(t, c.RemoveOldest())
+
+	c.Set("key1", newItemStub(10))
+	c.Set("key2", newItemStub(10))
+	c.Get("key1")
+
+	require.True(t, c.RemoveOldest())
+	require.Equal(t, []string{"key2"}, removed)
+	require.EqualValues(t, 10, c.size)
+
+	require.True(t, c.RemoveOldest())
+	require.False(t, c.RemoveOldest())
+	require.Equal(t, []string{"key2", "key1"}, removed)
+	require.Zero(t, c.size)
+}
+
+func TestCache_Remove(t *testing.T) {
+	var removed []string
+	c := NewCache(100, func(item *Item) {
+		removed = append(removed, item.key)
+	})
+	require.False(t, c.Remove("key1"))
+
+	c.Set("key1", newItemStub(10))
+	c.Set("key2", newItemStub(10))
+
+	require.True(t, c.Remove("key1"))
+	require.False(t, c.Remove("key1"))
+	require.Equal(t, []string{"key1"}, removed)
+	require.EqualValues(t, 10, c.size)
+
+	_, ok := c.Get("key1")
+	require.False(t, ok)
+	_, ok = c.Get("key2")
+	require.True(t, ok)
+}
+
+func TestCache_Expiry(t *testing.T) {
+	var removed []string
+	c := NewCache(100, func(item *Item) {
+		removed = append(removed, item.key)
+	})
+
+	now := time.Now()
+	c.Set("never", newItemStub(10))
+	c.Set("expired", &Item{Size: 10, ExpiresAt: now.Add(-time.Second)})
+	c.Set("soon", &Item{Size: 10, ExpiresAt: now.Add(time.Minute)})
+	c.Set("later", &Item{Size: 10, ExpiresAt: now.Add(time.Hour)})
+
+	_, ok := c.Get("expired")
+	require.False(t, ok)
+	require.Equal(t, []string{"expired"}, removed)
+	require.EqualValues(t, 30, c.size)
+
+	_, ok = c.Get("soon")
+	require.True(t, ok)
+
+	require.Equal(t, 1, c.RemoveExpired(now.Add(2*time.Minute)))
+	require.Equal(t, []string{"expired", "soon"}, removed)
+
+	require.Equal(t, 1, c.RemoveExpired(now.Add(time.Hour)), "expires at the time")
+	require.Zero(t, c.RemoveExpired(now.Add(24*time.Hour)))
+
+	_, ok = c.Get("never")
+	require.True(t, ok)
+	require.EqualValues(t, 10, c.size)
+}
@@ -5,6 +5,7 @@ package mocklru
 import (
 	lru "github.com/pustato/image-previewer/internal/cache/lru"
 	mock "github.com/stretchr/testify/mock"
+	time "time"
 )
 
 // Cache is an autogenerated mock type for the Cache type
@@ -35,6 +36,64 @@ func (_m *Cache) Get(key string) (*lru.Item, bool) {
 	return r0, r1
 }
 
+// Items provides a mock function with given fields:
+func (_m *Cache) Items() []*lru.Item {
+	ret := _m.Called()
+
+	var r0 []*lru.Item
+	if rf, ok := ret.Get(0).(func() []*lru.Item); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*lru.Item)
+		}
+	}
+
+	return r0
+}
+
+// Remove provides a mock function with given fields: key
+func (_m *Cache) Remove(key string) bool {
+	ret := _m.Called(key)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(key)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// RemoveExpired provides a mock function with given fields: now
+func (_m *Cache) RemoveExpired(now time.Time) int {
+	ret := _m.Called(now)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(time.Time) int); ok {
+		r0 = rf(now)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// RemoveOldest provides a mock function with given fields:
+func (_m *Cache) RemoveOldest() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // Set provides a mock function with given fields: key, item
 func (_m *Cache) Set(key string, item *lru.Item) bool {
 	ret := _m.Called(key, item)
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pustato/image-previewer/internal/cache/lru"
+)
+
+// metadataExtension is the extension of the files describing the cached previews. The preview
+// is complete only once its metadata is written next to it.
+const metadataExtension = "json"
+
+// Entry is a cached preview as listed by the admin server.
+type Entry struct {
+	Key string `json:"key"`
+	*lru.Item
+}
+
+// Entries returns the cached previews from the most to the least recently used.
+func (a *AppCacheDecorator) Entries() []Entry {
+	items := a.cache.Items()
+
+	entries := make([]Entry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, Entry{
+			Key:  item.Key(),
+			Item: item,
+		})
+	}
+
+	return entries
+}
+
+func metadataFileName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "." + metadataExtension
+}
+
+// encodeMetadata encodes the metadata of the item and records its size in the item.
+func encodeMetadata(item *lru.Item) ([]byte, error) {
+	content, err := json.Marshal(item)
+	if err != nil {
+		return nil, fmt.Errorf("encode metadata: %w", err)
+	}
+	item.MetadataSize = uint64(len(content))
+
+	return content, nil
+}
+
+func (a *AppCacheDecorator) readMetadata(name string) (*lru.Item, error) {
+	content, err := a.fs.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+
+	item := &lru.Item{}
+	if err := json.Unmarshal(content, item); err != nil {
+		return nil, fmt.Errorf("decode metadata %s: %w", name, err)
+	}
+	item.MetadataSize = uint64(len(content))
+
+	return item, nil
+}
+
+// evict is called by the index for the evicted previews. The hot tier is invalidated before
+// the files are removed, so it never serves a removed preview. In the shared mode the leader
+// removes the files, the other processes only forget the preview.
+func (a *AppCacheDecorator) evict(item *lru.Item) {
+	if a.hot != nil {
+		a.hot.remove(item.Key())
+	}
+
+	if a.shared == nil {
+		a.removeFiles(item)
+	}
+}
+
+// removeFiles removes the metadata first, so an interrupted removal leaves an incomplete preview
+// the restore gets rid of.
+func (a *AppCacheDecorator) removeFiles(item *lru.Item) {
+	_ = a.fs.RemoveFile(metadataFileName(item.FileName))
+	_ = a.fs.RemoveFile(item.FileName)
+}
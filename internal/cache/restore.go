@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pustato/image-previewer/internal/cache/filesystem"
+	"github.com/pustato/image-previewer/internal/cache/lru"
+	"github.com/pustato/image-previewer/internal/resizer"
+)
+
+// scannedItem is a complete preview found in the storage.
+type scannedItem struct {
+	key      string
+	item     *lru.Item
+	file     filesystem.FileInfo
+	metadata filesystem.FileInfo
+}
+
+// restore registers the previews left in the cache directory by the previous run. The previews are
+// added oldest first, so the limit evicts the least recently written ones. The expired previews,
+// foreign files and the previews left without valid metadata by an interrupted write are removed.
+// In the shared mode the incomplete previews may belong to the writes in progress in other processes,
+// so the leader removes them later along with the expired ones.
+func (a *AppCacheDecorator) restore() error {
+	restored, expired, garbage, err := a.scan(nil)
+	if err != nil {
+		return fmt.Errorf("cached app restore: %w", err)
+	}
+
+	if a.shared == nil {
+		for _, e := range expired {
+			a.removeFiles(e.item)
+		}
+
+		for _, file := range garbage {
+			if err := a.fs.RemoveFile(file.Name); err != nil {
+				return fmt.Errorf("cached app restore: %w", err)
+			}
+		}
+	}
+
+	for _, r := range restored {
+		a.cache.Set(r.key, r.item)
+	}
+
+	return nil
+}
+
+// scan returns the complete previews of the storage from the least recently written or used one,
+// the expired previews and the files not belonging to any of them. The metadata of the previews
+// scanned before, keyed by the metadata file names in known, is read again only when it has changed.
+func (a *AppCacheDecorator) scan(
+	known map[string]scannedItem,
+) ([]scannedItem, []scannedItem, []filesystem.FileInfo, error) {
+	files, err := a.fs.List()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	now := time.Now()
+
+	contents := make(map[string]filesystem.FileInfo, len(files)/2)
+	for _, file := range files {
+		if _, extension, ok := splitFileName(file.Name); ok && extension != metadataExtension {
+			contents[file.Name] = file
+		}
+	}
+
+	kept := make(map[string]bool, len(files))
+	scanned := make([]scannedItem, 0, len(contents))
+	var expired []scannedItem
+	for _, file := range files {
+		key, extension, ok := splitFileName(file.Name)
+		if !ok || extension != metadataExtension {
+			continue
+		}
+
+		item, err := a.scanMetadata(file, known)
+		if err != nil {
+			continue
+		}
+
+		content, ok := contents[item.FileName]
+		if !ok || content.Size != item.Size || metadataFileName(content.Name) != file.Name {
+			continue
+		}
+
+		// The limit may have been lowered since the preview was cached.
+		if a.oversized(item) {
+			continue
+		}
+
+		kept[file.Name], kept[content.Name] = true, true
+		if item.Expired(now) {
+			expired = append(expired, scannedItem{key, item, content, file})
+
+			continue
+		}
+		scanned = append(scanned, scannedItem{key, item, content, file})
+	}
+
+	garbage := make([]filesystem.FileInfo, 0, len(files)-len(kept))
+	for _, file := range files {
+		if !kept[file.Name] {
+			garbage = append(garbage, file)
+		}
+	}
+
+	// The content time tells when the preview was written, or used in the shared mode.
+	sort.Slice(scanned, func(i, j int) bool {
+		return scanned[i].file.ModTime.Before(scanned[j].file.ModTime)
+	})
+
+	return scanned, expired, garbage, nil
+}
+
+// scanMetadata returns the item of the metadata file, the known one when the file has not changed since
+// it was read.
+func (a *AppCacheDecorator) scanMetadata(file filesystem.FileInfo, known map[string]scannedItem) (*lru.Item, error) {
+	if k, ok := known[file.Name]; ok && k.metadata.Size == file.Size && k.metadata.ModTime.Equal(file.ModTime) {
+		return k.item, nil
+	}
+
+	return a.readMetadata(file.Name)
+}
+
+// splitFileName returns the cache key and the extension of the file named as the decorator names them.
+func splitFileName(name string) (string, string, bool) {
+	dot := strings.LastIndexByte(name, '.')
+	if dot <= 0 {
+		return "", "", false
+	}
+
+	key, extension := name[:dot], name[dot+1:]
+	if strings.ContainsAny(key, ".") {
+		return "", "", false
+	}
+
+	if extension == metadataExtension {
+		return key, extension, true
+	}
+
+	for _, format := range resizer.Formats {
+		if format.Extension() == extension {
+			return key, extension, true
+		}
+	}
+
+	return "", "", false
+}
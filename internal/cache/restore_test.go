@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mockapp "github.com/pustato/image-previewer/internal/app/mocks"
+	"github.com/pustato/image-previewer/internal/cache/filesystem"
+	"github.com/pustato/image-previewer/internal/cache/lru"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCacheFiles(t *testing.T, dir string, item *lru.Item, content []byte, modTime time.Time) {
+	t.Helper()
+
+	metadata, err := json.Marshal(item)
+	require.NoError(t, err)
+
+	contentPath := filepath.Join(dir, item.FileName)
+	require.NoError(t, os.WriteFile(contentPath, content, 0o600))
+	require.NoError(t, os.Chtimes(contentPath, modTime, modTime))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, metadataFileName(item.FileName)), metadata, 0o600))
+}
+
+func cacheFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var names []string
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+
+		return err
+	})
+	require.NoError(t, err)
+
+	return names
+}
+
+func TestNewCacheAppDecorator_Restore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	content := []byte("0123456789")
+	newItem := func(fileName string) *lru.Item {
+		return &lru.Item{
+			FileName: fileName,
+			Size:     uint64(len(content)),
+			ETag:     `"etag"`,
+			ModTime:  now.Add(-24 * time.Hour),
+			Header:   http.Header{"Cache-Control": {"max-age=60"}},
+		}
+	}
+
+	writeCacheFiles(t, dir, newItem("newest.jpg"), content, now)
+	writeCacheFiles(t, dir, newItem("oldest.png"), content, now.Add(-2*time.Hour))
+	writeCacheFiles(t, dir, newItem("older.gif"), content, now.Add(-time.Hour))
+
+	// The content is longer than the metadata tells.
+	writeCacheFiles(t, dir, newItem("truncated.jpg"), []byte("01234"), now)
+	// The metadata describes another file.
+	writeCacheFiles(t, dir, newItem("other.jpg"), content, now)
+	require.NoError(t, os.Rename(filepath.Join(dir, "other.json"), filepath.Join(dir, "renamed.json")))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foreign.txt"), content, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "no_metadata.jpg"), content, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "no_content.json"), []byte("{}"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "corrupted.jpg"), content, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "corrupted.json"), []byte("{"), 0o600))
+
+	fs, err := filesystem.NewDiskFilesystem(dir)
+	require.NoError(t, err)
+
+	// The limit fits two previews along with their metadata.
+	metadata, err := json.Marshal(newItem("newest.jpg"))
+	require.NoError(t, err)
+	limits := Limits{Size: uint64(2 * (len(content) + len(metadata)))}
+
+	unit, err := NewCacheAppDecorator(&mockapp.App{}, limits, fs)
+	require.NoError(t, err)
+
+	require.ElementsMatch(t, []string{"newest.jpg", "newest.json", "older.gif", "older.json"}, cacheFiles(t, dir))
+
+	entries := unit.Entries()
+	require.Len(t, entries, 2)
+	require.Equal(t, "newest", entries[0].Key)
+	require.Equal(t, "older", entries[1].Key)
+	require.Equal(t, `"etag"`, entries[1].ETag)
+	require.True(t, now.Add(-24*time.Hour).Equal(entries[1].ModTime))
+	require.Equal(t, http.Header{"Cache-Control": {"max-age=60"}}, entries[1].Header)
+}
+
+func TestNewCacheAppDecorator_RestoreHit(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("cached content")
+	key := NewHashKeyGenerator(nil).Key(url, opts, headers)
+	item := &lru.Item{
+		FileName: key + ".jpg",
+		Size:     uint64(len(content)),
+		ETag:     `"etag"`,
+		ModTime:  time.Now().Add(-time.Hour).Truncate(time.Second),
+		Header:   http.Header{"Expires": {"Thu, 01 Dec 2044 16:00:00 GMT"}},
+	}
+	writeCacheFiles(t, dir, item, content, time.Now())
+
+	fs, err := filesystem.NewDiskFilesystem(dir)
+	require.NoError(t, err)
+
+	unit, err := NewCacheAppDecorator(&mockapp.App{}, Limits{Size: 1024}, fs)
+	require.NoError(t, err)
+
+	img, err := unit.GetAndResize(ctx, url, opts, headers)
+	require.NoError(t, err)
+	require.IsType(t, &os.File{}, img.Body, "streamed from the disk")
+	require.Equal(t, content, readBody(t, img))
+	require.Equal(t, item.ETag, img.ETag)
+	require.True(t, item.ModTime.Equal(img.ModTime))
+	require.Equal(t, item.Header, img.Header)
+}
+
+func TestSplitFileName(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		extension string
+		valid     bool
+	}{
+		{name: "key.jpg", key: "key", extension: "jpg", valid: true},
+		{name: "key.png", key: "key", extension: "png", valid: true},
+		{name: "key.gif", key: "key", extension: "gif", valid: true},
+		{name: "key.json", key: "key", extension: "json", valid: true},
+		{name: "key.txt"},
+		{name: "key"},
+		{name: ".jpg"},
+		{name: "key.tmp.jpg"},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			key, extension, valid := splitFileName(tc.name)
+			require.Equal(t, tc.valid, valid)
+			require.Equal(t, tc.key, key)
+			require.Equal(t, tc.extension, extension)
+		})
+	}
+}
+
+func TestNewCacheAppDecorator_Volume(t *testing.T) {
+	fs := filesystem.NewMemoryFilesystem()
+
+	_, err := NewCacheAppDecorator(&mockapp.App{}, Limits{Size: 1024, BlockAccounting: true}, fs)
+	require.ErrorIs(t, err, filesystem.ErrVolumeStatNotSupported)
+
+	_, err = NewCacheAppDecorator(&mockapp.App{}, Limits{Size: 1024, MinFreeSpace: 1024}, fs)
+	require.ErrorIs(t, err, filesystem.ErrVolumeStatNotSupported)
+
+	_, err = NewCacheAppDecorator(&mockapp.App{}, Limits{Size: 1024}, fs)
+	require.NoError(t, err)
+}
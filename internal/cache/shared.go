@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pustato/image-previewer/internal/app"
+	"github.com/pustato/image-previewer/internal/cache/filesystem"
+	"github.com/pustato/image-previewer/internal/cache/lru"
+)
+
+const (
+	leaderLockName = "leader"
+	// indexLockName is locked shared by the writes of the previews and exclusively by the removals,
+	// so the leader never removes a half of a preview rewritten by another process.
+	indexLockName        = "index"
+	defaultSweepInterval = 10 * time.Second
+	// touchInterval throttles the updates of the recency of the shared previews.
+	touchInterval = time.Minute
+	// Previews without metadata older than this are treated as abandoned by crashed writers.
+	incompleteFileAge = time.Minute
+)
+
+// sharedStorage coordinates the processes sharing the storage. The metadata files are the shared
+// index: every process looks up the previews cached by the others there, and the modification times
+// of the contents tell their recency. The process holding the leader lock evicts the previews of all
+// of them, the local indexes only forget the previews.
+type sharedStorage struct {
+	fs            filesystem.Shared
+	limits        Limits
+	blockSize     uint64
+	sweepInterval time.Duration
+
+	mu      sync.Mutex
+	touched map[string]time.Time
+	// unlock is set while the process is the leader.
+	unlock func() error
+
+	// known holds the previews found by the last sweep by their metadata file names, so the next one
+	// reads only the metadata written since. Only the sweeps use it.
+	known map[string]scannedItem
+}
+
+// NewSharedCacheAppDecorator creates the decorator sharing the storage with other processes of the host,
+// e.g. the one created by filesystem.NewSharedDiskFilesystem. Run must be called for the previews to be
+// evicted. The storage may exceed the limits by the previews written between the sweeps of the leader.
+func NewSharedCacheAppDecorator(app app.App, limits Limits, fs filesystem.Filesystem) (*AppCacheDecorator, error) {
+	shared, ok := fs.(filesystem.Shared)
+	if !ok {
+		return nil, fmt.Errorf("new cached app: %w", filesystem.ErrSharedNotSupported)
+	}
+
+	return newCacheAppDecorator(app, limits, fs, &sharedStorage{
+		fs:            shared,
+		sweepInterval: defaultSweepInterval,
+		touched:       make(map[string]time.Time),
+	})
+}
+
+// WithSweepInterval sets how often the leader evicts the previews of the shared storage.
+func (a *AppCacheDecorator) WithSweepInterval(interval time.Duration) *AppCacheDecorator {
+	if a.shared != nil {
+		a.shared.sweepInterval = interval
+	}
+
+	return a
+}
+
+// runShared takes part in the election of the leader and evicts the previews while the process
+// is the leader.
+func (a *AppCacheDecorator) runShared() {
+	// A failed sweep is retried on the next tick.
+	if a.shared.elect() {
+		_ = a.sweep()
+	}
+	a.shared.prune()
+}
+
+// adopt registers the preview cached by another process.
+func (a *AppCacheDecorator) adopt(key string) (*lru.Item, bool) {
+	item, err := a.readMetadata(key + "." + metadataExtension)
+	if err != nil || item.Expired(time.Now()) {
+		return nil, false
+	}
+
+	if fileKey, _, ok := splitFileName(item.FileName); !ok || fileKey != key {
+		return nil, false
+	}
+
+	a.cache.Set(key, item)
+
+	return item, true
+}
+
+// sweep evicts the least recently used previews of the shared storage beyond the limits and removes
+// the files of the incomplete previews. The storage is listed on every sweep for the recency of the
+// previews, but the metadata is read only for the previews written since the last one.
+func (a *AppCacheDecorator) sweep() error {
+	scanned, expired, garbage, err := a.scan(a.shared.known)
+	if err != nil {
+		return fmt.Errorf("cached app sweep: %w", err)
+	}
+
+	a.shared.known = make(map[string]scannedItem, len(scanned))
+	for _, s := range scanned {
+		a.shared.known[s.metadata.Name] = s
+	}
+
+	if err := a.removeGarbage(expired, garbage); err != nil {
+		return fmt.Errorf("cached app sweep: %w", err)
+	}
+
+	index := a.shared.limits.newIndex(a.shared.blockSize, func(item *lru.Item) {
+		unlock, err := a.lockIndex(true)
+		if err != nil {
+			return
+		}
+		a.removeFiles(item)
+		_ = unlock()
+
+		a.cache.Remove(item.Key())
+	})
+	for _, s := range scanned {
+		index.Set(s.key, s.item)
+	}
+
+	for a.volume != nil {
+		stat, err := a.volume.Stat()
+		if err != nil {
+			return fmt.Errorf("cached app sweep: %w", err)
+		}
+
+		if stat.Free >= a.minFreeSpace || !index.RemoveOldest() {
+			break
+		}
+	}
+
+	return nil
+}
+
+// removeGarbage removes the expired previews and the files of the incomplete ones old enough
+// for no process to be writing them.
+func (a *AppCacheDecorator) removeGarbage(expired []scannedItem, garbage []filesystem.FileInfo) error {
+	unlock, err := a.lockIndex(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, e := range expired {
+		a.removeFiles(e.item)
+	}
+
+	for _, file := range garbage {
+		if time.Since(file.ModTime) >= incompleteFileAge {
+			_ = a.fs.RemoveFile(file.Name)
+		}
+	}
+
+	return nil
+}
+
+// lockIndex locks the shared index for a write of a preview, or exclusively for a removal.
+// It does nothing when the storage is not shared.
+func (a *AppCacheDecorator) lockIndex(exclusive bool) (func() error, error) {
+	if a.shared == nil {
+		return func() error { return nil }, nil
+	}
+
+	return a.shared.fs.Lock(indexLockName, exclusive)
+}
+
+// elect tries to take the leader lock, it returns whether the process is the leader.
+func (s *sharedStorage) elect() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.unlock != nil {
+		return true
+	}
+
+	unlock, err := s.fs.TryLock(leaderLockName)
+	if err != nil {
+		// ErrLocked means another process is the leader, the other errors are retried
+		// on the next election as well.
+		return false
+	}
+	s.unlock = unlock
+
+	return true
+}
+
+func (s *sharedStorage) resign() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.unlock != nil {
+		_ = s.unlock()
+		s.unlock = nil
+	}
+	s.known = nil
+}
+
+// touch marks the preview as used for the leader, at most once a touchInterval.
+func (s *sharedStorage) touch(fileName string) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if last, ok := s.touched[fileName]; ok && now.Sub(last) < touchInterval {
+		s.mu.Unlock()
+
+		return
+	}
+	s.touched[fileName] = now
+	s.mu.Unlock()
+
+	_ = s.fs.Touch(fileName)
+}
+
+// prune forgets the touches old enough to be repeated.
+func (s *sharedStorage) prune() {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for fileName, last := range s.touched {
+		if now.Sub(last) >= touchInterval {
+			delete(s.touched, fileName)
+		}
+	}
+}
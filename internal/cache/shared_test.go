@@ -0,0 +1,301 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pustato/image-previewer/internal/app"
+	"github.com/pustato/image-previewer/internal/cache/filesystem"
+	"github.com/pustato/image-previewer/internal/cache/lru"
+	"github.com/pustato/image-previewer/internal/resizer"
+	"github.com/stretchr/testify/require"
+)
+
+const sharedCacheDirEnv = "PREVIEWER_TEST_SHARED_CACHE_DIR"
+
+// widthApp renders the previews of the content derived from the width, so every process renders
+// the same previews.
+type widthApp struct {
+	calls int32
+}
+
+func (w *widthApp) GetAndResize(
+	_ context.Context,
+	_ string,
+	opts resizer.Options,
+	_ http.Header,
+) (*app.Image, error) {
+	atomic.AddInt32(&w.calls, 1)
+
+	return app.NewImage(widthContent(opts.Width), time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)), nil
+}
+
+func widthContent(width int) []byte {
+	return bytes.Repeat([]byte{byte('a' + width%26)}, width)
+}
+
+func widthOptions(width int) resizer.Options {
+	o := opts
+	o.Width = width
+
+	return o
+}
+
+func newSharedApp(t *testing.T, dir string, limits Limits) (*AppCacheDecorator, *widthApp) {
+	t.Helper()
+
+	fs, err := filesystem.NewSharedDiskFilesystem(dir)
+	if errors.Is(err, filesystem.ErrSharedNotSupported) {
+		t.Skip(err.Error())
+	}
+	require.NoError(t, err)
+
+	appp := &widthApp{}
+	unit, err := NewSharedCacheAppDecorator(appp, limits, fs)
+	require.NoError(t, err)
+
+	return unit, appp
+}
+
+// cachePath returns the path of the file in the shard directories.
+func cachePath(t *testing.T, dir, name string) string {
+	t.Helper()
+
+	var found string
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && entry.Name() == name {
+			found = path
+		}
+
+		return err
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, found, name)
+
+	return found
+}
+
+func listNames(t *testing.T, fs filesystem.Filesystem) []string {
+	t.Helper()
+
+	files, err := fs.List()
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+
+	return names
+}
+
+func getWidth(t *testing.T, unit *AppCacheDecorator, width int) {
+	t.Helper()
+
+	img, err := unit.GetAndResize(ctx, url, widthOptions(width), headers)
+	require.NoError(t, err)
+	require.Equal(t, widthContent(width), readBody(t, img))
+}
+
+func TestNewSharedCacheAppDecorator(t *testing.T) {
+	_, err := NewSharedCacheAppDecorator(&widthApp{}, Limits{Size: 100}, filesystem.NewMemoryFilesystem())
+	require.ErrorIs(t, err, filesystem.ErrSharedNotSupported)
+}
+
+func TestSharedCache_Adopt(t *testing.T) {
+	dir := t.TempDir()
+	first, firstApp := newSharedApp(t, dir, Limits{Size: 1000})
+	second, secondApp := newSharedApp(t, dir, Limits{Size: 1000})
+
+	getWidth(t, first, 10)
+	getWidth(t, second, 10)
+	getWidth(t, second, 10)
+
+	require.EqualValues(t, 1, firstApp.calls)
+	require.Zero(t, secondApp.calls, "the preview cached by another process")
+	require.Equal(t, Stats{Storage: TierStats{Hits: 2}}, second.Stats())
+}
+
+func TestSharedCache_Sweep(t *testing.T) {
+	dir := t.TempDir()
+	leader, _ := newSharedApp(t, dir, Limits{Entries: 2})
+	follower, followerApp := newSharedApp(t, dir, Limits{Entries: 2})
+
+	require.True(t, leader.shared.elect())
+	require.False(t, follower.shared.elect(), "one leader at a time")
+
+	// The local eviction of the follower keeps the files for the other processes.
+	for width := 10; width <= 13; width++ {
+		getWidth(t, follower, width)
+	}
+	require.Len(t, listNames(t, leader.fs), 8)
+
+	// The follower used the preview 10 after the others were written in order.
+	for width := 11; width <= 13; width++ {
+		name := leader.keys.Key(url, widthOptions(width), headers) + ".jpg"
+		old := time.Now().Add(time.Duration(width)*time.Second - time.Hour)
+		require.NoError(t, os.Chtimes(cachePath(t, dir, name), old, old))
+	}
+
+	// The incomplete previews are removed once no process may still be writing them.
+	require.NoError(t, leader.fs.WriteFile("abcdef.jpg", []byte("fresh")))
+	require.NoError(t, leader.fs.WriteFile("fedcba.jpg", []byte("stale")))
+	stale := time.Now().Add(-2 * incompleteFileAge)
+	require.NoError(t, os.Chtimes(cachePath(t, dir, "fedcba.jpg"), stale, stale))
+
+	require.NoError(t, leader.sweep())
+
+	files := listNames(t, leader.fs)
+	require.Contains(t, files, "abcdef.jpg")
+	require.NotContains(t, files, "fedcba.jpg")
+	require.Len(t, files, 5, "the previews 10 and 13 and the fresh file")
+
+	// The follower serves the evicted preview again.
+	calls := followerApp.calls
+	getWidth(t, follower, 11)
+	require.Equal(t, calls+1, followerApp.calls)
+
+	leader.shared.resign()
+	require.True(t, follower.shared.elect())
+	follower.shared.resign()
+}
+
+func TestSharedCache_SweepIncremental(t *testing.T) {
+	dir := t.TempDir()
+	leader, _ := newSharedApp(t, dir, Limits{Size: 5000})
+
+	getWidth(t, leader, 10)
+	require.NoError(t, leader.sweep())
+
+	metadataName := leader.keys.Key(url, widthOptions(10), headers) + "." + metadataExtension
+	path := cachePath(t, dir, metadataName)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// The unchanged metadata is not read again.
+	require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("{"), int(info.Size())), 0o600))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	require.NoError(t, leader.sweep())
+	require.Contains(t, leader.shared.known, metadataName)
+
+	modTime := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	require.NoError(t, leader.sweep())
+	require.NotContains(t, leader.shared.known, metadataName)
+}
+
+// TestSharedCache_HelperProcess is run by TestSharedCache_MultiProcess in the child processes.
+func TestSharedCache_HelperProcess(t *testing.T) {
+	dir := os.Getenv(sharedCacheDirEnv)
+	if dir == "" {
+		t.Skip("run by TestSharedCache_MultiProcess")
+	}
+
+	unit, _ := newSharedApp(t, dir, Limits{Size: 5000})
+	unit.WithSweepInterval(time.Millisecond)
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		unit.Run(runCtx)
+	}()
+
+	seed, err := strconv.ParseInt(os.Getenv(sharedCacheDirEnv+"_SEED"), 10, 64)
+	require.NoError(t, err)
+	rnd := rand.New(rand.NewSource(seed)) // nolint:gosec
+	for i := 0; i < 300; i++ {
+		getWidth(t, unit, 1+rnd.Intn(50))
+	}
+
+	cancel()
+	<-done
+}
+
+func TestSharedCache_MultiProcess(t *testing.T) {
+	if os.Getenv(sharedCacheDirEnv) != "" {
+		t.Skip("running in a child process")
+	}
+
+	const processes = 3
+
+	dir := t.TempDir()
+	parent, _ := newSharedApp(t, dir, Limits{Size: 5000})
+
+	cmds := make([]*exec.Cmd, processes)
+	outputs := make([]*bytes.Buffer, processes)
+	for i := range cmds {
+		outputs[i] = &bytes.Buffer{}
+		cmds[i] = exec.Command( // nolint:gosec
+			os.Args[0], "-test.run=^TestSharedCache_HelperProcess$", "-test.count=1", "-test.v",
+		)
+		cmds[i].Env = append(
+			os.Environ(),
+			sharedCacheDirEnv+"="+dir,
+			fmt.Sprintf("%s_SEED=%d", sharedCacheDirEnv, i),
+		)
+		cmds[i].Stdout, cmds[i].Stderr = outputs[i], outputs[i]
+		require.NoError(t, cmds[i].Start())
+	}
+	for i, cmd := range cmds {
+		require.NoError(t, cmd.Wait(), outputs[i].String())
+		require.Contains(t, outputs[i].String(), "--- PASS: TestSharedCache_HelperProcess")
+	}
+
+	require.NoError(t, parent.sweep())
+
+	files, err := parent.fs.List()
+	require.NoError(t, err)
+
+	size := uint64(0)
+	names := make(map[string]bool, len(files))
+	for _, file := range files {
+		names[file.Name] = true
+	}
+	for _, file := range files {
+		size += file.Size
+
+		key, extension, ok := splitFileName(file.Name)
+		require.True(t, ok, file.Name)
+		if extension == metadataExtension {
+			item, err := parent.readMetadata(file.Name)
+			require.NoError(t, err)
+			require.True(t, names[item.FileName], "content of %s", file.Name)
+			continue
+		}
+
+		require.True(t, names[key+"."+metadataExtension], "metadata of %s", file.Name)
+		content, err := parent.fs.ReadFile(file.Name)
+		require.NoError(t, err)
+		require.Equal(t, widthContent(len(content)), content)
+	}
+	require.LessOrEqual(t, size, uint64(5000))
+	require.NotZero(t, size)
+}
+
+func TestSharedCache_SweepExpired(t *testing.T) {
+	dir := t.TempDir()
+	leader, _ := newSharedApp(t, dir, Limits{Size: 1000})
+
+	expired := &lru.Item{FileName: "expired.jpg", Size: 1, ExpiresAt: time.Now().Add(-time.Second)}
+	fresh := &lru.Item{FileName: "fresh.jpg", Size: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	saveItem(t, leader, expired, []byte("1"))
+	saveItem(t, leader, fresh, []byte("1"))
+
+	_, ok := leader.adopt("expired")
+	require.False(t, ok)
+
+	require.NoError(t, leader.sweep())
+	require.ElementsMatch(t, []string{"fresh.jpg", "fresh.json"}, listNames(t, leader.fs))
+}
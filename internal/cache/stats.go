@@ -0,0 +1,39 @@
+package cache
+
+import "sync/atomic"
+
+// Stats counts the requests served by the decorator.
+type Stats struct {
+	// Memory counts the lookups of the in-memory hot tier, it stays zero when the tier is disabled.
+	Memory TierStats `json:"memory"`
+	// Storage counts the lookups of the cache storage the hot tier missed.
+	Storage TierStats `json:"storage"`
+	// Oversized counts the previews served without caching for exceeding the max item size.
+	Oversized uint64 `json:"oversized"`
+}
+
+// TierStats counts the hits and misses of a cache tier.
+type TierStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+func (s *Stats) snapshot() Stats {
+	return Stats{
+		Memory:    s.Memory.snapshot(),
+		Storage:   s.Storage.snapshot(),
+		Oversized: atomic.LoadUint64(&s.Oversized),
+	}
+}
+
+func (s *TierStats) snapshot() TierStats {
+	return TierStats{
+		Hits:   atomic.LoadUint64(&s.Hits),
+		Misses: atomic.LoadUint64(&s.Misses),
+	}
+}
+
+// Stats returns the counters collected since the start.
+func (a *AppCacheDecorator) Stats() Stats {
+	return a.stats.snapshot()
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAgeLimit caps the max-age as RFC 7234 suggests, so the larger values do not overflow.
+const maxAgeLimit = 1 << 31
+
+// expiresAt returns the time the preview of the source response expires at, zero means never.
+// The forwarded Cache-Control max-age and Expires of the source take precedence over the ttl.
+// It returns false when the preview expires at once and must not be cached.
+func expiresAt(header http.Header, ttl time.Duration, now time.Time) (time.Time, bool) {
+	// The cached previews are never revalidated, so no-cache makes them stale at once.
+	if hasDirective(header, "no-store") || hasDirective(header, "private") || hasDirective(header, "no-cache") {
+		return time.Time{}, false
+	}
+
+	if maxAge, ok := parseMaxAge(header); ok {
+		return now.Add(maxAge), maxAge > 0
+	}
+
+	if values := header.Values("Expires"); len(values) > 0 {
+		// The invalid dates, e.g. 0, mean the response is expired already.
+		expires, err := http.ParseTime(values[0])
+		if err != nil || !expires.After(now) {
+			return time.Time{}, false
+		}
+
+		return expires, true
+	}
+
+	if ttl > 0 {
+		return now.Add(ttl), true
+	}
+
+	return time.Time{}, true
+}
+
+// remainingHeader returns the header of the cached preview with the max-age of the source rewritten
+// to the remaining lifetime of the preview, so the clients do not keep it past the expiry of the cache.
+func remainingHeader(header http.Header, expires, now time.Time) http.Header {
+	if _, ok := parseMaxAge(header); !ok || expires.IsZero() {
+		return header
+	}
+
+	remaining := int64(0)
+	if expires.After(now) {
+		remaining = int64(expires.Sub(now) / time.Second)
+	}
+
+	values := header.Values("Cache-Control")
+	header = header.Clone()
+	header.Del("Cache-Control")
+	for _, value := range values {
+		directives := strings.Split(value, ",")
+		for i, directive := range directives {
+			directives[i] = strings.TrimSpace(directive)
+
+			if name, _ := splitDirective(directive); strings.EqualFold(name, "max-age") {
+				directives[i] = "max-age=" + strconv.FormatInt(remaining, 10)
+			}
+		}
+		header.Add("Cache-Control", strings.Join(directives, ", "))
+	}
+
+	return header
+}
+
+// parseMaxAge returns the max-age directive of the Cache-Control header.
+func parseMaxAge(header http.Header) (time.Duration, bool) {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, seconds := splitDirective(directive)
+			if !strings.EqualFold(name, "max-age") {
+				continue
+			}
+
+			// The invalid max-age makes the response stale.
+			age, err := strconv.ParseInt(seconds, 10, 64)
+			if err != nil || age < 0 {
+				return 0, true
+			}
+			if age > maxAgeLimit {
+				age = maxAgeLimit
+			}
+
+			return time.Duration(age) * time.Second, true
+		}
+	}
+
+	return 0, false
+}
+
+// hasDirective tells whether the Cache-Control header has the directive of the name.
+func hasDirective(header http.Header, name string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if directiveName, _ := splitDirective(directive); strings.EqualFold(directiveName, name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// splitDirective returns the name and the unquoted value of the Cache-Control directive.
+func splitDirective(directive string) (string, string) {
+	name, value := strings.TrimSpace(directive), ""
+	if eq := strings.IndexByte(name, '='); eq >= 0 {
+		name, value = strings.TrimSpace(name[:eq]), strings.Trim(strings.TrimSpace(name[eq+1:]), `"`)
+	}
+
+	return name, value
+}
@@ -0,0 +1,269 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pustato/image-previewer/internal/app"
+	mockapp "github.com/pustato/image-previewer/internal/app/mocks"
+	"github.com/pustato/image-previewer/internal/cache/filesystem"
+	"github.com/pustato/image-previewer/internal/cache/lru"
+	"github.com/pustato/image-previewer/internal/resizer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiresAt(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(2 * time.Hour).Format(http.TimeFormat)
+
+	testData := []struct {
+		name     string
+		header   http.Header
+		ttl      time.Duration
+		expected time.Time
+		ok       bool
+	}{
+		{"no expiry", http.Header{}, 0, time.Time{}, true},
+		{"ttl", http.Header{}, time.Hour, now.Add(time.Hour), true},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=60"}}, time.Hour, now.Add(time.Minute), true},
+		{"quoted max-age", http.Header{"Cache-Control": {`max-age="60"`}}, 0, now.Add(time.Minute), true},
+		{"max-age case", http.Header{"Cache-Control": {"Max-Age = 60"}}, 0, now.Add(time.Minute), true},
+		{"max-age=0", http.Header{"Cache-Control": {"max-age=0"}}, time.Hour, now, false},
+		{"invalid max-age", http.Header{"Cache-Control": {"max-age=soon"}}, time.Hour, now, false},
+		{
+			"huge max-age",
+			http.Header{"Cache-Control": {"max-age=99999999999999"}},
+			0, now.Add(maxAgeLimit * time.Second), true,
+		},
+		{
+			"other directives",
+			http.Header{"Cache-Control": {"no-transform", "s-maxage=10"}},
+			time.Hour, now.Add(time.Hour), true,
+		},
+		{"expires", http.Header{"Expires": {expires}}, time.Hour, now.Add(2 * time.Hour), true},
+		{
+			"max-age over expires",
+			http.Header{"Cache-Control": {"max-age=60"}, "Expires": {expires}},
+			0, now.Add(time.Minute), true,
+		},
+		{
+			"past expires",
+			http.Header{"Expires": {now.Add(-time.Hour).Format(http.TimeFormat)}},
+			time.Hour, time.Time{}, false,
+		},
+		{"invalid expires", http.Header{"Expires": {"0"}}, time.Hour, time.Time{}, false},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, time.Hour, time.Time{}, false},
+		{"private", http.Header{"Cache-Control": {"max-age=60, Private"}}, time.Hour, time.Time{}, false},
+		{"private fields", http.Header{"Cache-Control": {`private="Set-Cookie"`}}, 0, time.Time{}, false},
+		{
+			"no-cache",
+			http.Header{"Cache-Control": {"public", "no-cache"}, "Expires": {expires}},
+			time.Hour, time.Time{}, false,
+		},
+	}
+
+	for _, td := range testData {
+		td := td
+		t.Run(td.name, func(t *testing.T) {
+			actual, ok := expiresAt(td.header, td.ttl, now)
+			require.Equal(t, td.ok, ok)
+			if td.ok {
+				require.True(t, td.expected.Equal(actual), actual)
+			}
+		})
+	}
+}
+
+func TestRemainingHeader(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(2 * time.Hour).Format(http.TimeFormat)
+
+	testData := []struct {
+		name     string
+		header   http.Header
+		expires  time.Time
+		expected http.Header
+	}{
+		{
+			"max-age",
+			http.Header{"Cache-Control": {"public,Max-Age=3600"}, "Expires": {expires}},
+			now.Add(90 * time.Second),
+			http.Header{"Cache-Control": {"public, max-age=90"}, "Expires": {expires}},
+		},
+		{
+			"rounded down",
+			http.Header{"Cache-Control": {"no-transform", "max-age=60, s-maxage=60"}},
+			now.Add(1500 * time.Millisecond),
+			http.Header{"Cache-Control": {"no-transform", "max-age=1, s-maxage=60"}},
+		},
+		{
+			"expired",
+			http.Header{"Cache-Control": {"max-age=60"}},
+			now.Add(-time.Second),
+			http.Header{"Cache-Control": {"max-age=0"}},
+		},
+		{
+			"no max-age",
+			http.Header{"Cache-Control": {"public"}, "Expires": {expires}},
+			now.Add(time.Minute),
+			http.Header{"Cache-Control": {"public"}, "Expires": {expires}},
+		},
+		{
+			"never expires",
+			http.Header{"Cache-Control": {"max-age=60"}},
+			time.Time{},
+			http.Header{"Cache-Control": {"max-age=60"}},
+		},
+	}
+
+	for _, td := range testData {
+		td := td
+		t.Run(td.name, func(t *testing.T) {
+			original := td.header.Clone()
+
+			require.Equal(t, td.expected, remainingHeader(td.header, td.expires, now))
+			require.Equal(t, original, td.header, "the header of the item is kept")
+		})
+	}
+}
+
+func TestAppCacheDecorator_GetAndResize_TTL(t *testing.T) {
+	newApp := func(header http.Header) *mockapp.App {
+		appp := &mockapp.App{}
+		appp.
+			On("GetAndResize", anyContext, url, opts, headers).
+			Return(func(context.Context, string, resizer.Options, http.Header) *app.Image {
+				img := app.NewImage([]byte("preview"), time.Now())
+				img.Header = header
+
+				return img
+			}, nil)
+
+		return appp
+	}
+
+	t.Run("default ttl", func(t *testing.T) {
+		limits := Limits{Size: 1000, TTL: time.Hour}
+		unit, err := NewCacheAppDecorator(newApp(http.Header{}), limits, filesystem.NewMemoryFilesystem())
+		require.NoError(t, err)
+
+		_, err = unit.GetAndResize(ctx, url, opts, headers)
+		require.NoError(t, err)
+
+		entries := unit.Entries()
+		require.Len(t, entries, 1)
+		require.WithinDuration(t, time.Now().Add(time.Hour), entries[0].ExpiresAt, time.Minute)
+	})
+
+	t.Run("expired by the source", func(t *testing.T) {
+		appp := newApp(http.Header{"Cache-Control": {"max-age=0"}})
+		fs := filesystem.NewMemoryFilesystem()
+		unit, err := NewCacheAppDecorator(appp, Limits{Size: 1000, TTL: time.Hour}, fs)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			img, err := unit.GetAndResize(ctx, url, opts, headers)
+			require.NoError(t, err)
+			require.Equal(t, []byte("preview"), readBody(t, img))
+		}
+
+		appp.AssertNumberOfCalls(t, "GetAndResize", 2)
+		require.Empty(t, unit.Entries())
+		files, err := fs.List()
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+
+	t.Run("lazy expiry", func(t *testing.T) {
+		appp := newApp(http.Header{"Cache-Control": {"max-age=60"}})
+		fs := filesystem.NewMemoryFilesystem()
+		unit, err := NewCacheAppDecorator(appp, Limits{Size: 1000}, fs)
+		require.NoError(t, err)
+
+		_, err = unit.GetAndResize(ctx, url, opts, headers)
+		require.NoError(t, err)
+		unit.Entries()[0].ExpiresAt = time.Now().Add(-time.Second)
+
+		_, err = unit.GetAndResize(ctx, url, opts, headers)
+		require.NoError(t, err)
+
+		appp.AssertNumberOfCalls(t, "GetAndResize", 2)
+		require.Equal(t, Stats{Storage: TierStats{Misses: 2}}, unit.Stats())
+		require.True(t, unit.Entries()[0].ExpiresAt.After(time.Now()))
+	})
+
+	t.Run("remaining max-age of a hit", func(t *testing.T) {
+		appp := newApp(http.Header{"Cache-Control": {"public, max-age=3600"}})
+		unit, err := NewCacheAppDecorator(appp, Limits{Size: 1000}, filesystem.NewMemoryFilesystem())
+		require.NoError(t, err)
+
+		img, err := unit.GetAndResize(ctx, url, opts, headers)
+		require.NoError(t, err)
+		require.Equal(t, "public, max-age=3600", img.Header.Get("Cache-Control"))
+
+		unit.Entries()[0].ExpiresAt = time.Now().Add(10*time.Minute + time.Second)
+
+		img, err = unit.GetAndResize(ctx, url, opts, headers)
+		require.NoError(t, err)
+		require.Equal(t, "public, max-age=600", img.Header.Get("Cache-Control"))
+		require.Equal(t, "public, max-age=3600", unit.Entries()[0].Header.Get("Cache-Control"))
+	})
+}
+
+func TestAppCacheDecorator_Run_Janitor(t *testing.T) {
+	fs := filesystem.NewMemoryFilesystem()
+	unit, err := NewCacheAppDecorator(&mockapp.App{}, Limits{Size: 1000}, fs)
+	require.NoError(t, err)
+	unit.WithJanitorInterval(time.Millisecond)
+
+	for _, item := range []*lru.Item{
+		{FileName: "expired.jpg", Size: 1, ExpiresAt: time.Now().Add(-time.Second)},
+		{FileName: "fresh.jpg", Size: 1, ExpiresAt: time.Now().Add(time.Hour)},
+		{FileName: "never.jpg", Size: 1},
+	} {
+		saveItem(t, unit, item, []byte("1"))
+		unit.cache.Set(item.FileName[:len(item.FileName)-len(".jpg")], item)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		unit.Run(runCtx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(unit.Entries()) == 2
+	}, waitFor, time.Millisecond)
+	cancel()
+	<-done
+
+	files, err := fs.List()
+	require.NoError(t, err)
+	require.Len(t, files, 4, "the files of the expired preview are removed")
+	_, err = fs.ReadFile("expired.json")
+	require.ErrorIs(t, err, filesystem.ErrFileNotExists)
+}
+
+func TestNewCacheAppDecorator_RestoreExpired(t *testing.T) {
+	fs := filesystem.NewMemoryFilesystem()
+	unit, err := NewCacheAppDecorator(&mockapp.App{}, Limits{Size: 1000}, fs)
+	require.NoError(t, err)
+
+	expired := &lru.Item{FileName: "expired.jpg", Size: 1, ExpiresAt: time.Now().Add(-time.Second)}
+	fresh := &lru.Item{FileName: "fresh.jpg", Size: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	saveItem(t, unit, expired, []byte("1"))
+	saveItem(t, unit, fresh, []byte("1"))
+
+	restored, err := NewCacheAppDecorator(&mockapp.App{}, Limits{Size: 1000}, fs)
+	require.NoError(t, err)
+
+	entries := restored.Entries()
+	require.Len(t, entries, 1)
+	require.Equal(t, "fresh", entries[0].Key)
+	files, err := fs.List()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
@@ -0,0 +1,59 @@
+package resizer
+
+import "strings"
+
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatGIF  Format = "gif"
+)
+
+type formatInfo struct {
+	contentType string
+	extension   string
+	alpha       bool
+	lossy       bool
+}
+
+// formats lists every output format an encoder is available for.
+// A new format (webp, avif) needs an entry here and a case in imagingProcessor.Encode.
+var formats = map[Format]formatInfo{
+	FormatJPEG: {"image/jpeg", "jpg", false, true},
+	FormatPNG:  {"image/png", "png", true, false},
+	FormatGIF:  {"image/gif", "gif", false, false},
+}
+
+// Formats is the output formats in the order of server preference.
+var Formats = []Format{FormatJPEG, FormatPNG, FormatGIF}
+
+func ParseFormat(s string) (Format, bool) {
+	f := Format(strings.ToLower(s))
+	if f == "jpg" {
+		f = FormatJPEG
+	}
+
+	if _, ok := formats[f]; !ok {
+		return "", false
+	}
+
+	return f, true
+}
+
+func (f Format) ContentType() string {
+	return formats[f].contentType
+}
+
+func (f Format) Extension() string {
+	return formats[f].extension
+}
+
+func (f Format) SupportsAlpha() bool {
+	return formats[f].alpha
+}
+
+// Lossy reports whether the format is encoded with EncodeOptions.Quality.
+func (f Format) Lossy() bool {
+	return formats[f].lossy
+}
@@ -0,0 +1,40 @@
+package resizer_test
+
+import (
+	"testing"
+
+	"github.com/pustato/image-previewer/internal/resizer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	testData := []struct {
+		in       string
+		expected resizer.Format
+		ok       bool
+	}{
+		{"jpeg", resizer.FormatJPEG, true},
+		{"jpg", resizer.FormatJPEG, true},
+		{"JPG", resizer.FormatJPEG, true},
+		{"png", resizer.FormatPNG, true},
+		{"gif", resizer.FormatGIF, true},
+		{"webp", "", false},
+		{"", "", false},
+	}
+
+	for _, td := range testData {
+		actual, ok := resizer.ParseFormat(td.in)
+		require.Equal(t, td.ok, ok, td.in)
+		require.Equal(t, td.expected, actual, td.in)
+	}
+
+	for _, f := range resizer.Formats {
+		require.NotEmpty(t, f.ContentType())
+		require.NotEmpty(t, f.Extension())
+	}
+
+	require.True(t, resizer.FormatPNG.SupportsAlpha())
+	require.False(t, resizer.FormatJPEG.SupportsAlpha())
+	require.True(t, resizer.FormatJPEG.Lossy())
+	require.False(t, resizer.FormatPNG.Lossy())
+}
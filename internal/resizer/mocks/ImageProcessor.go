@@ -4,9 +4,12 @@ package mockresizer
 
 import (
 	image "image"
+	color "image/color"
 	io "io"
 
 	mock "github.com/stretchr/testify/mock"
+
+	resizer "github.com/pustato/image-previewer/internal/resizer"
 )
 
 // ImageProcessor is an autogenerated mock type for the ImageProcessor type
@@ -53,13 +56,13 @@ func (_m *ImageProcessor) Decode(reader io.Reader) (image.Image, error) {
 	return r0, r1
 }
 
-// Encode provides a mock function with given fields: img, writer
-func (_m *ImageProcessor) Encode(img image.Image, writer io.Writer) error {
-	ret := _m.Called(img, writer)
+// Encode provides a mock function with given fields: img, opts, writer
+func (_m *ImageProcessor) Encode(img image.Image, opts resizer.EncodeOptions, writer io.Writer) error {
+	ret := _m.Called(img, opts, writer)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(image.Image, io.Writer) error); ok {
-		r0 = rf(img, writer)
+	if rf, ok := ret.Get(0).(func(image.Image, resizer.EncodeOptions, io.Writer) error); ok {
+		r0 = rf(img, opts, writer)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -67,6 +70,22 @@ func (_m *ImageProcessor) Encode(img image.Image, writer io.Writer) error {
 	return r0
 }
 
+// Pad provides a mock function with given fields: img, width, height, background
+func (_m *ImageProcessor) Pad(img image.Image, width int, height int, background color.Color) image.Image {
+	ret := _m.Called(img, width, height, background)
+
+	var r0 image.Image
+	if rf, ok := ret.Get(0).(func(image.Image, int, int, color.Color) image.Image); ok {
+		r0 = rf(img, width, height, background)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(image.Image)
+		}
+	}
+
+	return r0
+}
+
 // Resize provides a mock function with given fields: img, width, height
 func (_m *ImageProcessor) Resize(img image.Image, width int, height int) image.Image {
 	ret := _m.Called(img, width, height)
@@ -6,6 +6,8 @@ import (
 	io "io"
 
 	mock "github.com/stretchr/testify/mock"
+
+	resizer "github.com/pustato/image-previewer/internal/resizer"
 )
 
 // Resizer is an autogenerated mock type for the Resizer type
@@ -13,13 +15,13 @@ type Resizer struct {
 	mock.Mock
 }
 
-// Resize provides a mock function with given fields: i, w, h
-func (_m *Resizer) Resize(i io.Reader, w int, h int) ([]byte, error) {
-	ret := _m.Called(i, w, h)
+// Resize provides a mock function with given fields: i, opts
+func (_m *Resizer) Resize(i io.Reader, opts resizer.Options) ([]byte, error) {
+	ret := _m.Called(i, opts)
 
 	var r0 []byte
-	if rf, ok := ret.Get(0).(func(io.Reader, int, int) []byte); ok {
-		r0 = rf(i, w, h)
+	if rf, ok := ret.Get(0).(func(io.Reader, resizer.Options) []byte); ok {
+		r0 = rf(i, opts)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]byte)
@@ -27,8 +29,8 @@ func (_m *Resizer) Resize(i io.Reader, w int, h int) ([]byte, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(io.Reader, int, int) error); ok {
-		r1 = rf(i, w, h)
+	if rf, ok := ret.Get(1).(func(io.Reader, resizer.Options) error); ok {
+		r1 = rf(i, opts)
 	} else {
 		r1 = ret.Error(1)
 	}
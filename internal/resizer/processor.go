@@ -3,6 +3,8 @@ package resizer
 import (
 	"fmt"
 	"image"
+	"image/color"
+	"image/png"
 	"io"
 
 	"github.com/disintegration/imaging"
@@ -10,11 +12,14 @@ import (
 
 var _ ImageProcessor = (*imagingProcessor)(nil)
 
+const DefaultQuality = 80
+
 type ImageProcessor interface {
 	Decode(reader io.Reader) (image.Image, error)
 	Crop(img image.Image, width, height int) image.Image
 	Resize(img image.Image, width, height int) image.Image
-	Encode(img image.Image, writer io.Writer) error
+	Pad(img image.Image, width, height int, background color.Color) image.Image
+	Encode(img image.Image, opts EncodeOptions, writer io.Writer) error
 }
 
 type imagingProcessor struct{}
@@ -36,9 +41,36 @@ func (i *imagingProcessor) Resize(img image.Image, width, height int) image.Imag
 	return imaging.Resize(img, width, height, imaging.Lanczos)
 }
 
-func (i *imagingProcessor) Encode(img image.Image, writer io.Writer) error {
-	if err := imaging.Encode(writer, img, imaging.JPEG, imaging.JPEGQuality(80)); err != nil {
-		return fmt.Errorf("imaging encode: %w", err)
+func (i *imagingProcessor) Pad(img image.Image, width, height int, background color.Color) image.Image {
+	return imaging.PasteCenter(imaging.New(width, height, background), img)
+}
+
+func (i *imagingProcessor) Encode(img image.Image, opts EncodeOptions, writer io.Writer) error {
+	var err error
+
+	switch opts.Format {
+	case FormatJPEG:
+		quality := opts.Quality
+		if quality == 0 {
+			quality = DefaultQuality
+		}
+
+		err = imaging.Encode(writer, img, imaging.JPEG, imaging.JPEGQuality(quality))
+	case FormatPNG:
+		compression := png.DefaultCompression
+		if opts.Optimize {
+			compression = png.BestCompression
+		}
+
+		err = imaging.Encode(writer, img, imaging.PNG, imaging.PNGCompressionLevel(compression))
+	case FormatGIF:
+		err = imaging.Encode(writer, img, imaging.GIF)
+	default:
+		err = ErrUnknownFormat
+	}
+
+	if err != nil {
+		return fmt.Errorf("imaging encode %s: %w", opts.Format, err)
 	}
 
 	return nil
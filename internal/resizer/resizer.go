@@ -2,14 +2,76 @@ package resizer
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
 	"io"
+	"math"
 )
 
 var _ Resizer = (*ImageResizer)(nil)
 
+var (
+	ErrUnknownOperation = errors.New("unknown operation")
+	ErrInvalidSize      = errors.New("invalid size")
+	ErrUnknownFormat    = errors.New("unknown format")
+)
+
+// DecodeError is returned when the source is not an image of a supported format.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "decode: " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+type Operation string
+
+const (
+	// OperationFill crops the image to the target ratio and scales it to the target size.
+	OperationFill Operation = "fill"
+	// OperationFit scales the image to fit inside the target size keeping its ratio.
+	OperationFit Operation = "fit"
+	// OperationCrop cuts the target size out of the image center without scaling.
+	OperationCrop Operation = "crop"
+	// OperationResize scales the image to the target size ignoring its ratio.
+	OperationResize Operation = "resize"
+)
+
+func ParseOperation(s string) (Operation, bool) {
+	switch op := Operation(s); op {
+	case OperationFill, OperationFit, OperationCrop, OperationResize:
+		return op, true
+	default:
+		return "", false
+	}
+}
+
+type Options struct {
+	Operation Operation
+	// Width and Height of the preview, one of them may be 0 to derive it from the source ratio.
+	Width, Height int
+	// Background fills the space left around the image by OperationFit.
+	Background color.NRGBA
+	EncodeOptions
+}
+
+type EncodeOptions struct {
+	Format Format
+	// Quality of the lossy formats from 1 to 100, 0 means DefaultQuality.
+	Quality int
+	// Optimize trades encoding time for a smaller output of the lossless formats.
+	Optimize bool
+}
+
 type Resizer interface {
-	Resize(i io.Reader, w, h int) ([]byte, error)
+	Resize(i io.Reader, opts Options) ([]byte, error)
 }
 
 func NewImageResizer() *ImageResizer {
@@ -28,12 +90,60 @@ func (r *ImageResizer) WithProcessor(processor ImageProcessor) *ImageResizer {
 	return r
 }
 
-func (r *ImageResizer) Resize(reader io.Reader, w, h int) ([]byte, error) {
+func (r *ImageResizer) Resize(reader io.Reader, opts Options) ([]byte, error) {
+	if opts.Width < 0 || opts.Height < 0 || (opts.Width == 0 && opts.Height == 0) {
+		return nil, fmt.Errorf("ImageResizer %dx%d: %w", opts.Width, opts.Height, ErrInvalidSize)
+	}
+
 	img, err := r.processor.Decode(reader)
 	if err != nil {
-		return nil, fmt.Errorf("ImageResizer decode: %w", err)
+		return nil, fmt.Errorf("ImageResizer: %w", &DecodeError{err})
+	}
+
+	w, h := targetSize(img, opts.Width, opts.Height)
+
+	op := opts.Operation
+	if (opts.Width == 0 || opts.Height == 0) && (op == OperationFill || op == OperationFit) {
+		// The derived size keeps the source ratio already, there is nothing to crop or pad.
+		op = OperationResize
 	}
 
+	switch op {
+	case OperationFill:
+		img = r.fill(img, w, h)
+	case OperationFit:
+		img = r.fit(img, w, h, opts.Background)
+	case OperationCrop:
+		img = r.processor.Crop(img, w, h)
+	case OperationResize:
+		img = r.processor.Resize(img, w, h)
+	default:
+		return nil, fmt.Errorf("ImageResizer %s: %w", opts.Operation, ErrUnknownOperation)
+	}
+
+	buff := new(bytes.Buffer)
+	if err := r.processor.Encode(img, opts.EncodeOptions, buff); err != nil {
+		return nil, fmt.Errorf("ImageResizer encode: %w", err)
+	}
+
+	return buff.Bytes(), nil
+}
+
+// targetSize derives the zero dimension from the source ratio.
+func targetSize(img image.Image, w, h int) (int, int) {
+	wi, hi := img.Bounds().Dx(), img.Bounds().Dy()
+
+	switch {
+	case w == 0:
+		w = int(math.Max(1, math.Round(float64(h)*float64(wi)/float64(hi))))
+	case h == 0:
+		h = int(math.Max(1, math.Round(float64(w)*float64(hi)/float64(wi))))
+	}
+
+	return w, h
+}
+
+func (r *ImageResizer) fill(img image.Image, w, h int) image.Image {
 	wi, hi := img.Bounds().Dx(), img.Bounds().Dy()
 	targetRatio := float64(w) / float64(h)
 	currentRatio := float64(wi) / float64(hi)
@@ -51,12 +161,21 @@ func (r *ImageResizer) Resize(reader io.Reader, w, h int) ([]byte, error) {
 		img = r.processor.Crop(img, cropW, cropH)
 	}
 
-	img = r.processor.Resize(img, w, h)
+	return r.processor.Resize(img, w, h)
+}
 
-	buff := new(bytes.Buffer)
-	if err := r.processor.Encode(img, buff); err != nil {
-		return nil, fmt.Errorf("ImageResizer encode: %w", err)
+func (r *ImageResizer) fit(img image.Image, w, h int, background color.Color) image.Image {
+	wi, hi := img.Bounds().Dx(), img.Bounds().Dy()
+	scale := math.Min(float64(w)/float64(wi), float64(h)/float64(hi))
+
+	fitW := int(math.Max(1, math.Round(float64(wi)*scale)))
+	fitH := int(math.Max(1, math.Round(float64(hi)*scale)))
+
+	img = r.processor.Resize(img, fitW, fitH)
+
+	if fitW != w || fitH != h {
+		img = r.processor.Pad(img, w, h, background)
 	}
 
-	return buff.Bytes(), nil
+	return img
 }
@@ -1,4 +1,4 @@
-package resizer
+package resizer_test
 
 import (
 	"bytes"
@@ -9,6 +9,7 @@ import (
 	"io"
 	"testing"
 
+	"github.com/pustato/image-previewer/internal/resizer"
 	mockresizer "github.com/pustato/image-previewer/internal/resizer/mocks"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -110,18 +111,251 @@ func TestImageResizer_Resize_Crop(t *testing.T) {
 				Return(resizedImg)
 
 			processor.
-				On("Encode", resizedImg, anyWriter).
+				On("Encode", resizedImg, resizer.EncodeOptions{Format: resizer.FormatJPEG}, anyWriter).
 				Once().
 				Return(nil)
 
-			resizer := NewImageResizer().WithProcessor(processor)
+			unit := resizer.NewImageResizer().WithProcessor(processor)
 
-			_, err := resizer.Resize(in, td.w, td.h)
+			_, err := unit.Resize(in, resizer.Options{
+				Operation:     resizer.OperationFill,
+				Width:         td.w,
+				Height:        td.h,
+				EncodeOptions: resizer.EncodeOptions{Format: resizer.FormatJPEG},
+			})
 			require.NoError(t, err)
 		})
 	}
 }
 
+func TestImageResizer_Resize_Fit(t *testing.T) {
+	t.Parallel()
+
+	white := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	red := color.NRGBA{R: 0xff, A: 0xff}
+
+	testData := []struct {
+		iw, ih, w, h, fitW, fitH int
+		bg                       color.NRGBA
+		expectPad                bool
+	}{
+		{
+			4000, 3000, 50, 50, 50, 38, white, true,
+		},
+
+		{
+			1024, 768, 2000, 50, 67, 50, red, true,
+		},
+
+		{
+			1024, 768, 50, 1000, 50, 38, color.NRGBA{}, true,
+		},
+
+		{
+			1024, 504, 2048, 2048, 2048, 1008, white, true,
+		},
+
+		{
+			1000, 2000, 100, 200, 100, 200, white, false,
+		},
+
+		{
+			1024, 768, 2048, 1536, 2048, 1536, red, false,
+		},
+
+		{
+			5000, 10, 100, 100, 100, 1, red, true,
+		},
+	}
+
+	for i, td := range testData {
+		td := td
+
+		t.Run(fmt.Sprintf("fit case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			img := newImageStub(td.iw, td.ih)
+			resizedImg := newImageStub(td.fitW, td.fitH)
+			paddedImg := newImageStub(td.w, td.h)
+			in := new(bytes.Buffer)
+
+			processor := &mockresizer.ImageProcessor{}
+			processor.
+				On("Decode", in).
+				Once().
+				Return(img, nil)
+
+			processor.
+				On("Resize", img, td.fitW, td.fitH).
+				Once().
+				Return(resizedImg)
+
+			if td.expectPad {
+				processor.
+					On("Pad", resizedImg, td.w, td.h, td.bg).
+					Once().
+					Return(paddedImg)
+			} else {
+				paddedImg = resizedImg
+			}
+
+			processor.
+				On("Encode", paddedImg, resizer.EncodeOptions{Format: resizer.FormatJPEG}, anyWriter).
+				Once().
+				Return(nil)
+
+			unit := resizer.NewImageResizer().WithProcessor(processor)
+
+			_, err := unit.Resize(in, resizer.Options{
+				Operation:     resizer.OperationFit,
+				Width:         td.w,
+				Height:        td.h,
+				Background:    td.bg,
+				EncodeOptions: resizer.EncodeOptions{Format: resizer.FormatJPEG},
+			})
+			require.NoError(t, err)
+			processor.AssertExpectations(t)
+		})
+	}
+}
+
+func TestImageResizer_Resize_AutoSize(t *testing.T) {
+	t.Parallel()
+
+	testData := []struct {
+		op                   resizer.Operation
+		iw, ih, w, h, rW, rH int
+		expectCrop           bool
+	}{
+		{resizer.OperationFill, 1024, 768, 300, 0, 300, 225, false},
+		{resizer.OperationFill, 1024, 768, 0, 300, 400, 300, false},
+		{resizer.OperationFill, 1024, 504, 100, 0, 100, 49, false},
+		{resizer.OperationFit, 1024, 504, 0, 50, 102, 50, false},
+		{resizer.OperationResize, 1000, 10, 10, 0, 10, 1, false},
+		{resizer.OperationCrop, 1024, 768, 300, 0, 300, 225, true},
+	}
+
+	for i, td := range testData {
+		td := td
+
+		t.Run(fmt.Sprintf("auto size case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			img := newImageStub(td.iw, td.ih)
+			resultImg := newImageStub(td.rW, td.rH)
+			in := new(bytes.Buffer)
+
+			processor := &mockresizer.ImageProcessor{}
+			processor.
+				On("Decode", in).
+				Once().
+				Return(img, nil)
+
+			if td.expectCrop {
+				processor.
+					On("Crop", img, td.rW, td.rH).
+					Once().
+					Return(resultImg)
+			} else {
+				processor.
+					On("Resize", img, td.rW, td.rH).
+					Once().
+					Return(resultImg)
+			}
+
+			processor.
+				On("Encode", resultImg, resizer.EncodeOptions{Format: resizer.FormatJPEG}, anyWriter).
+				Once().
+				Return(nil)
+
+			unit := resizer.NewImageResizer().WithProcessor(processor)
+
+			_, err := unit.Resize(in, resizer.Options{
+				Operation:     td.op,
+				Width:         td.w,
+				Height:        td.h,
+				EncodeOptions: resizer.EncodeOptions{Format: resizer.FormatJPEG},
+			})
+			require.NoError(t, err)
+			processor.AssertExpectations(t)
+		})
+	}
+}
+
+func TestImageResizer_Resize_Operations(t *testing.T) {
+	t.Parallel()
+
+	t.Run("crop", func(t *testing.T) {
+		t.Parallel()
+
+		img := newImageStub(1024, 768)
+		croppedImg := newImageStub(300, 200)
+		in := new(bytes.Buffer)
+
+		processor := &mockresizer.ImageProcessor{}
+		processor.
+			On("Decode", in).
+			Once().
+			Return(img, nil)
+
+		processor.
+			On("Crop", img, 300, 200).
+			Once().
+			Return(croppedImg)
+
+		processor.
+			On("Encode", croppedImg, resizer.EncodeOptions{Format: resizer.FormatJPEG, Quality: 90}, anyWriter).
+			Once().
+			Return(nil)
+
+		unit := resizer.NewImageResizer().WithProcessor(processor)
+
+		_, err := unit.Resize(in, resizer.Options{
+			Operation:     resizer.OperationCrop,
+			Width:         300,
+			Height:        200,
+			EncodeOptions: resizer.EncodeOptions{Format: resizer.FormatJPEG, Quality: 90},
+		})
+		require.NoError(t, err)
+		processor.AssertExpectations(t)
+	})
+
+	t.Run("resize", func(t *testing.T) {
+		t.Parallel()
+
+		img := newImageStub(1024, 768)
+		resizedImg := newImageStub(300, 200)
+		in := new(bytes.Buffer)
+
+		processor := &mockresizer.ImageProcessor{}
+		processor.
+			On("Decode", in).
+			Once().
+			Return(img, nil)
+
+		processor.
+			On("Resize", img, 300, 200).
+			Once().
+			Return(resizedImg)
+
+		processor.
+			On("Encode", resizedImg, resizer.EncodeOptions{Format: resizer.FormatPNG, Optimize: true}, anyWriter).
+			Once().
+			Return(nil)
+
+		unit := resizer.NewImageResizer().WithProcessor(processor)
+
+		_, err := unit.Resize(in, resizer.Options{
+			Operation:     resizer.OperationResize,
+			Width:         300,
+			Height:        200,
+			EncodeOptions: resizer.EncodeOptions{Format: resizer.FormatPNG, Optimize: true},
+		})
+		require.NoError(t, err)
+		processor.AssertExpectations(t)
+	})
+}
+
 func TestImageResizer_Resize_Errors(t *testing.T) {
 	t.Run("decode error", func(t *testing.T) {
 		expectedErr := errors.New("test error")
@@ -133,11 +367,54 @@ func TestImageResizer_Resize_Errors(t *testing.T) {
 			Once().
 			Return(nil, expectedErr)
 
-		resizer := NewImageResizer().WithProcessor(processor)
+		unit := resizer.NewImageResizer().WithProcessor(processor)
 
-		_, err := resizer.Resize(in, 0, 0)
+		_, err := unit.Resize(in, resizer.Options{
+			Operation:     resizer.OperationFill,
+			Width:         100,
+			Height:        100,
+			EncodeOptions: resizer.EncodeOptions{Format: resizer.FormatJPEG},
+		})
 		require.Error(t, err)
 		require.ErrorIs(t, err, expectedErr)
+
+		var decodeErr *resizer.DecodeError
+		require.ErrorAs(t, err, &decodeErr)
+	})
+
+	t.Run("invalid size", func(t *testing.T) {
+		for _, size := range [][2]int{{0, 0}, {-1, 100}, {100, -1}, {-1, 0}} {
+			unit := resizer.NewImageResizer().WithProcessor(&mockresizer.ImageProcessor{})
+
+			_, err := unit.Resize(new(bytes.Buffer), resizer.Options{
+				Operation: resizer.OperationFill,
+				Width:     size[0],
+				Height:    size[1],
+			})
+			require.Error(t, err)
+			require.ErrorIs(t, err, resizer.ErrInvalidSize)
+		}
+	})
+
+	t.Run("unknown operation", func(t *testing.T) {
+		in := new(bytes.Buffer)
+
+		processor := &mockresizer.ImageProcessor{}
+		processor.
+			On("Decode", in).
+			Once().
+			Return(newImageStub(100, 100), nil)
+
+		unit := resizer.NewImageResizer().WithProcessor(processor)
+
+		_, err := unit.Resize(in, resizer.Options{
+			Operation:     "zoom",
+			Width:         10,
+			Height:        10,
+			EncodeOptions: resizer.EncodeOptions{Format: resizer.FormatJPEG},
+		})
+		require.Error(t, err)
+		require.ErrorIs(t, err, resizer.ErrUnknownOperation)
 	})
 
 	t.Run("encode error", func(t *testing.T) {
@@ -158,12 +435,17 @@ func TestImageResizer_Resize_Errors(t *testing.T) {
 			Return(resizedImg)
 
 		processor.
-			On("Encode", resizedImg, anyWriter).
+			On("Encode", resizedImg, resizer.EncodeOptions{Format: resizer.FormatJPEG}, anyWriter).
 			Once().
 			Return(expectedErr)
 
-		resizer := NewImageResizer().WithProcessor(processor)
-		_, err := resizer.Resize(in, 1000, 1000)
+		unit := resizer.NewImageResizer().WithProcessor(processor)
+		_, err := unit.Resize(in, resizer.Options{
+			Operation:     resizer.OperationFill,
+			Width:         1000,
+			Height:        1000,
+			EncodeOptions: resizer.EncodeOptions{Format: resizer.FormatJPEG},
+		})
 		require.Error(t, err)
 		require.ErrorIs(t, err, expectedErr)
 	})
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pustato/image-previewer/internal/resizer"
+)
+
+const (
+	specificityAny = iota
+	specificityType
+	specificityExact
+)
+
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// negotiateFormat picks the output format the Accept header prefers. Formats of equal quality are
+// ordered by the more specific media range first and by the server preference after that.
+// JPEG is the fallback for an empty header or a header nothing acceptable is found in.
+func negotiateFormat(accept string) resizer.Format {
+	return negotiate(accept, resizer.Formats, resizer.FormatJPEG)
+}
+
+// negotiateAlphaFormat picks the output format the Accept header prefers among the formats supporting
+// the alpha channel. PNG is the fallback.
+func negotiateAlphaFormat(accept string) resizer.Format {
+	formats := make([]resizer.Format, 0, len(resizer.Formats))
+	for _, f := range resizer.Formats {
+		if f.SupportsAlpha() {
+			formats = append(formats, f)
+		}
+	}
+
+	return negotiate(accept, formats, resizer.FormatPNG)
+}
+
+func negotiate(accept string, formats []resizer.Format, fallback resizer.Format) resizer.Format {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return fallback
+	}
+
+	best, bestQ, bestSpecificity := fallback, 0.0, specificityAny
+	for _, f := range formats {
+		q, specificity := acceptQuality(ranges, f.ContentType())
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = f, q, specificity
+		}
+	}
+
+	return best
+}
+
+// acceptQuality returns the quality of the most specific media range matching the content type.
+func acceptQuality(ranges []mediaRange, contentType string) (float64, int) {
+	typ, subtype := splitMediaType(contentType)
+	q, specificity := 0.0, -1
+
+	for _, r := range ranges {
+		var s int
+
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = specificityExact
+		case r.typ == typ && r.subtype == "*":
+			s = specificityType
+		case r.typ == "*" && r.subtype == "*":
+			s = specificityAny
+		default:
+			continue
+		}
+
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+
+	return q, specificity
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+
+		typ, subtype := splitMediaType(params[0])
+		if typ == "" || subtype == "" {
+			continue
+		}
+
+		r := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				r.q = q
+			}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+func splitMediaType(mediaType string) (string, string) {
+	parts := strings.SplitN(strings.ToLower(strings.TrimSpace(mediaType)), "/", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// acceptsJSON tells whether the client asks for application/json explicitly.
+func acceptsJSON(accept string) bool {
+	q, specificity := acceptQuality(parseAccept(accept), "application/json")
+
+	return q > 0 && specificity == specificityExact
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pustato/image-previewer/internal/resizer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	testData := []struct {
+		accept   string
+		expected resizer.Format
+	}{
+		{"", resizer.FormatJPEG},
+		{"*/*", resizer.FormatJPEG},
+		{"text/html", resizer.FormatJPEG},
+		{"image/webp,image/apng,image/*,*/*;q=0.8", resizer.FormatJPEG},
+		{"image/png", resizer.FormatPNG},
+		{"image/png,image/*;q=0.8", resizer.FormatPNG},
+		{"image/png;q=0.5,image/gif", resizer.FormatGIF},
+		{"image/jpeg;q=0.1, image/*", resizer.FormatPNG},
+		{"image/png;q=0.9, image/jpeg ; q=0.9", resizer.FormatJPEG},
+		{"image/jpeg;q=0,*/*;q=0.5", resizer.FormatPNG},
+		{"IMAGE/GIF", resizer.FormatGIF},
+		{"image/avif,image/webp", resizer.FormatJPEG},
+		{"garbage", resizer.FormatJPEG},
+	}
+
+	for _, td := range testData {
+		require.Equal(t, td.expected, negotiateFormat(td.accept), td.accept)
+	}
+}
+
+func TestNegotiateAlphaFormat(t *testing.T) {
+	testData := []struct {
+		accept   string
+		expected resizer.Format
+	}{
+		{"", resizer.FormatPNG},
+		{"*/*", resizer.FormatPNG},
+		{"image/jpeg", resizer.FormatPNG},
+		{"image/gif,image/png;q=0.5", resizer.FormatPNG},
+		{"image/webp,image/apng,image/*,*/*;q=0.8", resizer.FormatPNG},
+	}
+
+	for _, td := range testData {
+		require.Equal(t, td.expected, negotiateAlphaFormat(td.accept), td.accept)
+	}
+}
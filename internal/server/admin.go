@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pustato/image-previewer/internal/cache"
+	"github.com/pustato/image-previewer/internal/logger"
+)
+
+const (
+	adminCachePath = "/cache"
+	adminStatsPath = "/stats"
+)
+
+// CacheInspector exposes the cache state to the admin server.
+type CacheInspector interface {
+	Entries() []cache.Entry
+	Stats() cache.Stats
+}
+
+type cacheListing struct {
+	Count   int           `json:"count"`
+	Size    uint64        `json:"size"`
+	Entries []cache.Entry `json:"entries"`
+}
+
+// AdminHandler serves the cache listing, it is not meant to be exposed to the clients.
+type AdminHandler struct {
+	cache CacheInspector
+	log   logger.Logger
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body interface{}
+
+	switch r.URL.Path {
+	case adminCachePath:
+		body = h.listing()
+	case adminStatsPath:
+		body = h.cache.Stats()
+	default:
+		writeError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, r, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		h.log.Warn("write admin response: " + err.Error())
+	}
+}
+
+func (h *AdminHandler) listing() cacheListing {
+	listing := cacheListing{
+		Entries: h.cache.Entries(),
+	}
+	listing.Count = len(listing.Entries)
+	for _, entry := range listing.Entries {
+		listing.Size += entry.Size
+	}
+
+	return listing
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pustato/image-previewer/internal/cache"
+	"github.com/pustato/image-previewer/internal/cache/lru"
+	mocklogger "github.com/pustato/image-previewer/internal/logger/mocks"
+	"github.com/stretchr/testify/require"
+)
+
+type cacheStub struct {
+	entries []cache.Entry
+	stats   cache.Stats
+}
+
+func (c *cacheStub) Entries() []cache.Entry {
+	return c.entries
+}
+
+func (c *cacheStub) Stats() cache.Stats {
+	return c.stats
+}
+
+func TestAdminHandler(t *testing.T) {
+	stub := &cacheStub{
+		entries: []cache.Entry{
+			{Key: "key1", Item: &lru.Item{FileName: "key1.jpg", Size: 10, URL: "http://example.com/1.jpg"}},
+			{Key: "key2", Item: &lru.Item{FileName: "key2.png", Size: 20, URL: "http://example.com/2.png"}},
+		},
+		stats: cache.Stats{
+			Memory:    cache.TierStats{Hits: 3, Misses: 2},
+			Storage:   cache.TierStats{Hits: 1, Misses: 1},
+			Oversized: 1,
+		},
+	}
+	handler := &AdminHandler{cache: stub, log: &mocklogger.Logger{}}
+
+	t.Run("listing", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var body struct {
+			Count   int    `json:"count"`
+			Size    uint64 `json:"size"`
+			Entries []struct {
+				Key      string `json:"key"`
+				FileName string `json:"fileName"`
+				URL      string `json:"url"`
+			} `json:"entries"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		require.Equal(t, 2, body.Count)
+		require.EqualValues(t, 30, body.Size)
+		require.Equal(t, "key1", body.Entries[0].Key)
+		require.Equal(t, "key1.jpg", body.Entries[0].FileName)
+		require.Equal(t, "http://example.com/2.png", body.Entries[1].URL)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(
+			t,
+			`{"memory": {"hits": 3, "misses": 2}, "storage": {"hits": 1, "misses": 1}, "oversized": 1}`,
+			w.Body.String(),
+		)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/cache", nil))
+
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		require.Equal(t, "GET, HEAD", w.Header().Get("Allow"))
+	})
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+const (
+	transparentColor = "transparent"
+	shortHexColorLen = 3
+	hexColorLen      = 6
+	hexAlphaColorLen = 8
+)
+
+var (
+	defaultBackground = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	transparent       = color.NRGBA{}
+)
+
+// parseColor accepts "transparent" or a hex color in rgb, rrggbb or rrggbbaa form with an optional leading #.
+func parseColor(s string) (color.NRGBA, error) {
+	s = strings.ToLower(strings.TrimPrefix(s, "#"))
+	if s == transparentColor {
+		return transparent, nil
+	}
+
+	switch len(s) {
+	case shortHexColorLen:
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]}) + "ff"
+	case hexColorLen:
+		s += "ff"
+	case hexAlphaColorLen:
+	default:
+		return color.NRGBA{}, fmt.Errorf("%s: %w", s, ErrInvalidBackground)
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("%s: %w", s, ErrInvalidBackground)
+	}
+
+	return color.NRGBA{R: b[0], G: b[1], B: b[2], A: b[3]}, nil
+}
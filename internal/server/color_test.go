@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseColor(t *testing.T) {
+	t.Parallel()
+
+	testData := []struct {
+		in       string
+		expected color.NRGBA
+	}{
+		{"transparent", color.NRGBA{}},
+		{"Transparent", color.NRGBA{}},
+		{"fff", color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+		{"#0a0", color.NRGBA{G: 0xaa, A: 0xff}},
+		{"102030", color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff}},
+		{"#A0B0C0", color.NRGBA{R: 0xa0, G: 0xb0, B: 0xc0, A: 0xff}},
+		{"10203040", color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0x40}},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := parseColor(td.in)
+			require.NoError(t, err)
+			require.Equal(t, td.expected, actual)
+		})
+	}
+
+	for _, in := range []string{"", "#", "ff", "fffff", "fffffff", "gggggg", "white"} {
+		_, err := parseColor(in)
+		require.ErrorIs(t, err, ErrInvalidBackground, in)
+	}
+}
@@ -1,10 +1,75 @@
 package server
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/pustato/image-previewer/internal/app"
+	"github.com/pustato/image-previewer/internal/resizer"
+)
 
 var (
-	ErrMalformedRequestPath = errors.New("malformed request path")
-	ErrWidthIsNotANumber    = errors.New("width is not a number")
-	ErrHeightIsNotANumber   = errors.New("height is not a number")
-	ErrInvalidURL           = errors.New("invalid url")
+	ErrMalformedRequestPath    = errors.New("malformed request path")
+	ErrWidthIsNotANumber       = errors.New("width is not a number")
+	ErrHeightIsNotANumber      = errors.New("height is not a number")
+	ErrInvalidURL              = errors.New("invalid url")
+	ErrNegativeSize            = errors.New("width and height must not be negative")
+	ErrZeroSize                = errors.New("width and height must not be both zero")
+	ErrInvalidBackground       = errors.New("invalid background color")
+	ErrTransparentBackground   = errors.New("transparent background is not supported by output format")
+	ErrUnsupportedFormat       = errors.New("unsupported output format")
+	ErrInvalidQuality          = errors.New("quality must be a number from 1 to 100")
+	ErrInvalidFlag             = errors.New("invalid flag value")
+	ErrProgressiveNotSupported = errors.New("progressive encoding is not supported")
+	ErrNegativeMaxAge          = errors.New("cache max age must not be negative")
 )
+
+// isBadRequest tells the request errors answered with 400 from the malformed paths answered with 404.
+func isBadRequest(err error) bool {
+	return errors.Is(err, ErrNegativeSize) ||
+		errors.Is(err, ErrZeroSize) ||
+		errors.Is(err, ErrInvalidBackground) ||
+		errors.Is(err, ErrTransparentBackground) ||
+		errors.Is(err, ErrUnsupportedFormat) ||
+		errors.Is(err, ErrInvalidQuality) ||
+		errors.Is(err, ErrInvalidFlag) ||
+		errors.Is(err, ErrProgressiveNotSupported)
+}
+
+// proxiedStatuses are the statuses of the source server passed to the client as is.
+var proxiedStatuses = map[int]bool{
+	http.StatusForbidden: true,
+	http.StatusNotFound:  true,
+	http.StatusGone:      true,
+}
+
+// appErrorResponse maps an app error to the response status, the message telling the client
+// what happened and the upstream headers to pass along.
+func appErrorResponse(err error) (int, string, http.Header) {
+	var upstreamErr *app.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		if proxiedStatuses[upstreamErr.StatusCode] {
+			return upstreamErr.StatusCode, "source image: " + http.StatusText(upstreamErr.StatusCode), upstreamErr.Header
+		}
+
+		if upstreamErr.StatusCode != 0 {
+			return http.StatusBadGateway, upstreamErr.Error(), nil
+		}
+
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return http.StatusGatewayTimeout, "source server timed out", nil
+		}
+
+		return http.StatusBadGateway, "source server is unavailable", nil
+	}
+
+	var decodeErr *resizer.DecodeError
+	if errors.As(err, &decodeErr) {
+		return http.StatusUnsupportedMediaType, "source is not an image of a supported format", nil
+	}
+
+	return http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil
+}
@@ -1,14 +1,17 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/goware/urlx"
 	"github.com/pustato/image-previewer/internal/app"
 	"github.com/pustato/image-previewer/internal/logger"
+	"github.com/pustato/image-previewer/internal/resizer"
 )
 
 const (
@@ -16,41 +19,206 @@ const (
 	pathPartsWidthIdx  = 1
 	pathPartsHeightIdx = 2
 	pathPartsURLIdx    = 3
-	badRequestText     = "bad request"
+	backgroundParam    = "bg"
+	formatParam        = "format"
+	qualityParam       = "q"
+	optimizeParam      = "optimize"
+	progressiveParam   = "progressive"
+	minQuality         = 1
+	maxQuality         = 100
 )
 
 type Handler struct {
-	app app.App
-	log logger.Logger
+	app    app.App
+	log    logger.Logger
+	config Config
+}
+
+type errorBody struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
 }
 
 type request struct {
-	w, h int
+	opts resizer.Options
 	url  string
+	// negotiated is set when the format is chosen by the Accept header.
+	negotiated bool
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	rq, err := parsePath(r.URL.Path)
+	rq, err := h.parseRequest(r)
 	if err != nil {
-		h.log.Warn("parse path " + r.URL.Path + ": " + err.Error())
-		w.WriteHeader(http.StatusNotFound)
-		_, _ = w.Write([]byte(err.Error()))
+		h.log.Warn("parse request " + r.URL.String() + ": " + err.Error())
+		if isBadRequest(err) {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+		} else {
+			writeError(w, r, http.StatusNotFound, err.Error())
+		}
 		return
 	}
 
-	resized, err := h.app.GetAndResize(r.Context(), rq.url, rq.w, rq.h, r.Header)
+	img, err := h.app.GetAndResize(r.Context(), rq.url, rq.opts, h.upstreamHeaders(r))
 	if err != nil {
 		h.log.Warn("get and resize: " + err.Error())
-		w.WriteHeader(http.StatusBadGateway)
-		_, _ = w.Write([]byte(badRequestText))
+
+		status, message, header := appErrorResponse(err)
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		writeError(w, r, status, message)
+		return
+	}
+	defer img.Body.Close()
+
+	header := w.Header()
+	for key, values := range img.Header {
+		header[key] = values
+	}
+
+	// The source ETag validates the source image, not the preview rendered from it.
+	header.Set("ETag", img.ETag)
+	header.Set("Content-Type", rq.opts.Format.ContentType())
+	if header.Get("Cache-Control") == "" && header.Get("Expires") == "" {
+		header.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.config.CacheMaxAge.Seconds())))
+	}
+	if rq.negotiated {
+		header.Add("Vary", "Accept")
+	}
+
+	modTime := img.ModTime
+	if lastModified, err := http.ParseTime(img.Header.Get("Last-Modified")); err == nil {
+		modTime = lastModified
+	}
+
+	// ServeContent answers the conditional and Range requests and writes Content-Length and Last-Modified.
+	// The previews streamed from the disk are sent with sendfile.
+	http.ServeContent(w, r, "", modTime, img.Body)
+}
+
+// writeError answers with JSON to the clients asking for it and with plain text otherwise.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if acceptsJSON(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(errorBody{status, message})
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(resized)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(message))
+}
+
+func (h *Handler) parseRequest(r *http.Request) (*request, error) {
+	rq, err := parsePath(r.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+
+	if err := parseQuery(rq, query, r.Header); err != nil {
+		return nil, err
+	}
+
+	if err := h.parseEncodeOptions(rq, query); err != nil {
+		return nil, err
+	}
+
+	return rq, nil
+}
+
+func (h *Handler) parseEncodeOptions(rq *request, query url.Values) error {
+	rq.opts.Quality = h.config.DefaultQuality
+
+	if q := query.Get(qualityParam); q != "" {
+		quality, err := strconv.Atoi(q)
+		if err != nil || quality < minQuality || quality > maxQuality {
+			return fmt.Errorf("%s: %w", q, ErrInvalidQuality)
+		}
+
+		rq.opts.Quality = quality
+	}
+
+	if rq.opts.Quality < h.config.MinQuality {
+		rq.opts.Quality = h.config.MinQuality
+	}
+
+	if rq.opts.Quality > h.config.MaxQuality {
+		rq.opts.Quality = h.config.MaxQuality
+	}
+
+	optimize, err := parseFlag(query, optimizeParam)
+	if err != nil {
+		return err
+	}
+	rq.opts.Optimize = optimize
+
+	progressive, err := parseFlag(query, progressiveParam)
+	if err != nil {
+		return err
+	}
+
+	if progressive {
+		return ErrProgressiveNotSupported
+	}
+
+	return nil
+}
+
+func parseFlag(query url.Values, name string) (bool, error) {
+	v := query.Get(name)
+	if v == "" {
+		return false, nil
+	}
+
+	flag, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s=%s: %w", name, v, ErrInvalidFlag)
+	}
+
+	return flag, nil
+}
+
+func parseQuery(rq *request, query url.Values, header http.Header) error {
+	if f := query.Get(formatParam); f != "" {
+		format, ok := resizer.ParseFormat(f)
+		if !ok {
+			return fmt.Errorf("%s: %w", f, ErrUnsupportedFormat)
+		}
+
+		rq.opts.Format = format
+	} else {
+		rq.opts.Format = negotiateFormat(header.Get("Accept"))
+		rq.negotiated = true
+	}
+
+	rq.opts.Background = defaultBackground
+
+	if bg := query.Get(backgroundParam); bg != "" {
+		c, err := parseColor(bg)
+		if err != nil {
+			return err
+		}
+
+		if c.A != 0xff && !rq.opts.Format.SupportsAlpha() {
+			if !rq.negotiated {
+				return fmt.Errorf("%s: %w", bg, ErrTransparentBackground)
+			}
+
+			rq.opts.Format = negotiateAlphaFormat(header.Get("Accept"))
+		}
+
+		rq.opts.Background = c
+	}
+
+	return nil
 }
 
 func parsePath(path string) (*request, error) {
+	op, path := parseOperation(path)
+
 	parts := strings.SplitN(path, `/`, pathPartsExpected)
 	if len(parts) != pathPartsExpected {
 		return nil, ErrMalformedRequestPath
@@ -66,12 +234,44 @@ func parsePath(path string) (*request, error) {
 		return nil, fmt.Errorf("%s: %w", parts[pathPartsHeightIdx], ErrHeightIsNotANumber)
 	}
 
+	if w < 0 || h < 0 {
+		return nil, fmt.Errorf("%dx%d: %w", w, h, ErrNegativeSize)
+	}
+
+	if w == 0 && h == 0 {
+		return nil, ErrZeroSize
+	}
+
 	u, err := normalizeURL("http://" + parts[pathPartsURLIdx])
 	if err != nil {
 		return nil, err
 	}
 
-	return &request{w, h, u}, nil
+	return &request{
+		opts: resizer.Options{
+			Operation: op,
+			Width:     w,
+			Height:    h,
+		},
+		url: u,
+	}, nil
+}
+
+// parseOperation cuts the leading operation segment off the path.
+// Paths without it are the legacy /{w}/{h}/{url} form of fill.
+func parseOperation(path string) (resizer.Operation, string) {
+	rest := strings.TrimPrefix(path, "/")
+
+	idx := strings.IndexByte(rest, '/')
+	if idx < 0 {
+		return resizer.OperationFill, path
+	}
+
+	if op, ok := resizer.ParseOperation(rest[:idx]); ok {
+		return op, rest[idx:]
+	}
+
+	return resizer.OperationFill, path
 }
 
 func normalizeURL(u string) (string, error) {
@@ -1,52 +1,76 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"image/color"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/pustato/image-previewer/internal/app"
 	mockapp "github.com/pustato/image-previewer/internal/app/mocks"
 	mocklogger "github.com/pustato/image-previewer/internal/logger/mocks"
+	"github.com/pustato/image-previewer/internal/resizer"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
+var anyHeader = mock.AnythingOfType("http.Header")
+
+func newOptions(op resizer.Operation, w, h int) resizer.Options {
+	return resizer.Options{
+		Operation:  op,
+		Width:      w,
+		Height:     h,
+		Background: defaultBackground,
+		EncodeOptions: resizer.EncodeOptions{
+			Format:  resizer.FormatJPEG,
+			Quality: resizer.DefaultQuality,
+		},
+	}
+}
+
 func TestHandler_ServeHTTP_Success(t *testing.T) {
 	t.Parallel()
 
 	testData := []struct {
 		rq   *http.Request
 		url  string
-		w, h int
+		opts resizer.Options
 	}{
 		{
 			httptest.NewRequest(http.MethodGet, "http://x/100/200/www.example.com/image.jpg", nil),
 			"http://www.example.com/image.jpg",
-			100, 200,
+			newOptions(resizer.OperationFill, 100, 200),
 		},
 		{
 			httptest.NewRequest(http.MethodGet, "http://x/1/1/www.example.com/image.jpg?param=not_encoded", nil),
 			"http://www.example.com/image.jpg",
-			1, 1,
+			newOptions(resizer.OperationFill, 1, 1),
 		},
 		{
 			httptest.NewRequest(http.MethodGet, "http://x/1/1/www.example.com/image.jpg?#fragment_not_encoded", nil),
 			"http://www.example.com/image.jpg",
-			1, 1,
+			newOptions(resizer.OperationFill, 1, 1),
 		},
 		{
 			httptest.NewRequest(http.MethodGet, "http://x/1024/768/www.example.com/Image.JPG", nil),
 			"http://www.example.com/image.jpg",
-			1024, 768,
+			newOptions(resizer.OperationFill, 1024, 768),
 		},
 		{
 			httptest.NewRequest(http.MethodGet, "http://x/12/14/www.example.com/Image.JPG%3Fv%3D1", nil),
 			"http://www.example.com/image.jpg?v=1",
-			12, 14,
+			newOptions(resizer.OperationFill, 12, 14),
 		},
 		{
 			httptest.NewRequest(
@@ -55,7 +79,7 @@ func TestHandler_ServeHTTP_Success(t *testing.T) {
 				nil,
 			),
 			"http://www.example.com/image.jpg?a_order=first&b_order=second",
-			12, 14,
+			newOptions(resizer.OperationFill, 12, 14),
 		},
 		{
 			httptest.NewRequest(
@@ -64,12 +88,12 @@ func TestHandler_ServeHTTP_Success(t *testing.T) {
 				nil,
 			),
 			"http://www.example.com/image.jpg?a_order=first&b_order=second",
-			12, 14,
+			newOptions(resizer.OperationFill, 12, 14),
 		},
 		{
 			httptest.NewRequest(http.MethodGet, "http://x/1/1/www.example.com/Image.JPG%3Fv%3D1%23fragment", nil),
 			"http://www.example.com/image.jpg?v=1",
-			1, 1,
+			newOptions(resizer.OperationFill, 1, 1),
 		},
 
 		{
@@ -82,7 +106,47 @@ func TestHandler_ServeHTTP_Success(t *testing.T) {
 				return rq
 			}(),
 			"http://www.example.com/image.jpg?v=1",
-			1, 1,
+			newOptions(resizer.OperationFill, 1, 1),
+		},
+		{
+			httptest.NewRequest(http.MethodGet, "http://x/fill/300/200/www.example.com/image.jpg", nil),
+			"http://www.example.com/image.jpg",
+			newOptions(resizer.OperationFill, 300, 200),
+		},
+		{
+			httptest.NewRequest(http.MethodGet, "http://x/fit/300/200/www.example.com/image.jpg", nil),
+			"http://www.example.com/image.jpg",
+			newOptions(resizer.OperationFit, 300, 200),
+		},
+		{
+			httptest.NewRequest(http.MethodGet, "http://x/crop/300/200/www.example.com/image.jpg", nil),
+			"http://www.example.com/image.jpg",
+			newOptions(resizer.OperationCrop, 300, 200),
+		},
+		{
+			httptest.NewRequest(http.MethodGet, "http://x/resize/300/200/www.example.com/fit/1/2", nil),
+			"http://www.example.com/fit/1/2",
+			newOptions(resizer.OperationResize, 300, 200),
+		},
+		{
+			httptest.NewRequest(http.MethodGet, "http://x/fill/300/0/www.example.com/image.jpg", nil),
+			"http://www.example.com/image.jpg",
+			newOptions(resizer.OperationFill, 300, 0),
+		},
+		{
+			httptest.NewRequest(http.MethodGet, "http://x/0/200/www.example.com/image.jpg", nil),
+			"http://www.example.com/image.jpg",
+			newOptions(resizer.OperationFill, 0, 200),
+		},
+		{
+			httptest.NewRequest(http.MethodGet, "http://x/fit/300/200/www.example.com/image.jpg?bg=ff0000", nil),
+			"http://www.example.com/image.jpg",
+			func() resizer.Options {
+				opts := newOptions(resizer.OperationFit, 300, 200)
+				opts.Background = color.NRGBA{R: 0xff, A: 0xff}
+
+				return opts
+			}(),
 		},
 	}
 
@@ -90,17 +154,18 @@ func TestHandler_ServeHTTP_Success(t *testing.T) {
 		td := td
 		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
 			t.Parallel()
-			result := []byte("success result")
+			result := app.NewImage([]byte("success result"), time.Now())
 
-			app := &mockapp.App{}
-			app.
-				On("GetAndResize", td.rq.Context(), td.url, td.w, td.h, td.rq.Header).
+			appp := &mockapp.App{}
+			appp.
+				On("GetAndResize", td.rq.Context(), td.url, td.opts, anyHeader).
 				Once().
 				Return(result, nil)
 
 			h := Handler{
-				app: app,
-				log: &mocklogger.Logger{},
+				app:    appp,
+				log:    &mocklogger.Logger{},
+				config: DefaultConfig(),
 			}
 
 			w := httptest.NewRecorder()
@@ -110,7 +175,7 @@ func TestHandler_ServeHTTP_Success(t *testing.T) {
 			body, _ := io.ReadAll(rsp.Body)
 
 			require.Equal(t, http.StatusOK, rsp.StatusCode)
-			require.EqualValues(t, result, body)
+			require.EqualValues(t, "success result", body)
 
 			rsp.Body.Close()
 		})
@@ -135,6 +200,11 @@ func TestHandler_ServeHTTP_PathErrors(t *testing.T) {
 		{"http://x/1/22.4/x", ErrHeightIsNotANumber},
 
 		{"http://x/1/22.4/x", ErrHeightIsNotANumber},
+
+		{"http://x/fill/1/1", ErrMalformedRequestPath},
+		{"http://x/fit/x/1/x", ErrWidthIsNotANumber},
+		{"http://x/crop/1/x/x", ErrHeightIsNotANumber},
+		{"http://x/zoom/1/1/x", ErrWidthIsNotANumber},
 	}
 
 	for i, td := range testData {
@@ -151,8 +221,9 @@ func TestHandler_ServeHTTP_PathErrors(t *testing.T) {
 			rq := httptest.NewRequest(http.MethodGet, td.url, nil)
 
 			h := Handler{
-				app: &mockapp.App{},
-				log: logg,
+				app:    &mockapp.App{},
+				log:    logg,
+				config: DefaultConfig(),
 			}
 
 			h.ServeHTTP(w, rq)
@@ -168,34 +239,515 @@ func TestHandler_ServeHTTP_PathErrors(t *testing.T) {
 	}
 }
 
+func TestHandler_ServeHTTP_BadRequestErrors(t *testing.T) {
+	t.Parallel()
+
+	testData := []struct {
+		url string
+		err error
+	}{
+		{"http://x/0/0/x", ErrZeroSize},
+		{"http://x/fit/0/0/x", ErrZeroSize},
+		{"http://x/-1/100/x", ErrNegativeSize},
+		{"http://x/crop/100/-100/x", ErrNegativeSize},
+		{"http://x/fit/1/1/x?bg=red", ErrInvalidBackground},
+		{"http://x/fit/1/1/x?bg=fffff", ErrInvalidBackground},
+		{"http://x/fit/1/1/x?bg=transparent&format=jpeg", ErrTransparentBackground},
+		{"http://x/fit/1/1/x?bg=ffffff80&format=jpg", ErrTransparentBackground},
+		{"http://x/fit/1/1/x?bg=transparent&format=gif", ErrTransparentBackground},
+		{"http://x/fit/1/1/x?format=webp", ErrUnsupportedFormat},
+		{"http://x/1/1/x?q=0", ErrInvalidQuality},
+		{"http://x/1/1/x?q=101", ErrInvalidQuality},
+		{"http://x/1/1/x?q=high", ErrInvalidQuality},
+		{"http://x/1/1/x?optimize=maybe", ErrInvalidFlag},
+		{"http://x/1/1/x?progressive=1", ErrProgressiveNotSupported},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			logg := &mocklogger.Logger{}
+			logg.On("Warn", mock.MatchedBy(func(msg string) bool {
+				return strings.Contains(msg, td.err.Error())
+			}))
+
+			w := httptest.NewRecorder()
+			rq := httptest.NewRequest(http.MethodGet, td.url, nil)
+
+			h := Handler{
+				app:    &mockapp.App{},
+				log:    logg,
+				config: DefaultConfig(),
+			}
+
+			h.ServeHTTP(w, rq)
+
+			rsp := w.Result()
+			body, _ := io.ReadAll(rsp.Body)
+
+			require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+			require.Contains(t, string(body), td.err.Error())
+
+			rsp.Body.Close()
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_Format(t *testing.T) {
+	t.Parallel()
+
+	testData := []struct {
+		url, accept string
+		format      resizer.Format
+		bg          color.NRGBA
+		vary        bool
+	}{
+		{"http://x/1/1/x", "", resizer.FormatJPEG, defaultBackground, true},
+		{"http://x/1/1/x", "image/png", resizer.FormatPNG, defaultBackground, true},
+		{"http://x/1/1/x", "image/webp,image/*;q=0.8", resizer.FormatJPEG, defaultBackground, true},
+		{"http://x/1/1/x?format=gif", "image/png", resizer.FormatGIF, defaultBackground, false},
+		{"http://x/1/1/x?format=jpg", "", resizer.FormatJPEG, defaultBackground, false},
+		{"http://x/1/1/x?format=png&bg=transparent", "", resizer.FormatPNG, color.NRGBA{}, false},
+		{"http://x/1/1/x?bg=transparent", "image/png", resizer.FormatPNG, color.NRGBA{}, true},
+		{"http://x/1/1/x?bg=transparent", "", resizer.FormatPNG, color.NRGBA{}, true},
+		{"http://x/1/1/x?bg=transparent", "image/jpeg", resizer.FormatPNG, color.NRGBA{}, true},
+		{"http://x/1/1/x?bg=ffffff80", "image/gif,image/png;q=0.5", resizer.FormatPNG, color.NRGBA{255, 255, 255, 128}, true},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			rq := httptest.NewRequest(http.MethodGet, td.url, nil)
+			if td.accept != "" {
+				rq.Header.Set("Accept", td.accept)
+			}
+
+			opts := newOptions(resizer.OperationFill, 1, 1)
+			opts.Format = td.format
+			opts.Background = td.bg
+
+			appp := &mockapp.App{}
+			appp.
+				On("GetAndResize", rq.Context(), "http://x", opts, anyHeader).
+				Once().
+				Return(app.NewImage([]byte("success result"), time.Now()), nil)
+
+			h := Handler{
+				app:    appp,
+				log:    &mocklogger.Logger{},
+				config: DefaultConfig(),
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, rq)
+
+			rsp := w.Result()
+			defer rsp.Body.Close()
+
+			require.Equal(t, http.StatusOK, rsp.StatusCode)
+			require.Equal(t, td.format.ContentType(), rsp.Header.Get("Content-Type"))
+			if td.vary {
+				require.Equal(t, "Accept", rsp.Header.Get("Vary"))
+			} else {
+				require.Empty(t, rsp.Header.Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_EncodeOptions(t *testing.T) {
+	t.Parallel()
+
+	config := Config{
+		DefaultQuality: 75,
+		MinQuality:     30,
+		MaxQuality:     90,
+	}
+
+	testData := []struct {
+		url      string
+		expected resizer.EncodeOptions
+	}{
+		{"http://x/1/1/x", resizer.EncodeOptions{Format: resizer.FormatJPEG, Quality: 75}},
+		{"http://x/1/1/x?q=50", resizer.EncodeOptions{Format: resizer.FormatJPEG, Quality: 50}},
+		{"http://x/1/1/x?q=10", resizer.EncodeOptions{Format: resizer.FormatJPEG, Quality: 30}},
+		{"http://x/1/1/x?q=100", resizer.EncodeOptions{Format: resizer.FormatJPEG, Quality: 90}},
+		{
+			"http://x/1/1/x?format=png&optimize=1",
+			resizer.EncodeOptions{Format: resizer.FormatPNG, Quality: 75, Optimize: true},
+		},
+		{"http://x/1/1/x?optimize=false&progressive=false", resizer.EncodeOptions{Format: resizer.FormatJPEG, Quality: 75}},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			rq := httptest.NewRequest(http.MethodGet, td.url, nil)
+
+			opts := newOptions(resizer.OperationFill, 1, 1)
+			opts.EncodeOptions = td.expected
+
+			appp := &mockapp.App{}
+			appp.
+				On("GetAndResize", rq.Context(), "http://x", opts, anyHeader).
+				Once().
+				Return(app.NewImage([]byte("success result"), time.Now()), nil)
+
+			h := Handler{
+				app:    appp,
+				log:    &mocklogger.Logger{},
+				config: config,
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, rq)
+
+			rsp := w.Result()
+			defer rsp.Body.Close()
+
+			require.Equal(t, http.StatusOK, rsp.StatusCode)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_Headers(t *testing.T) {
+	t.Parallel()
+
+	modTime := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	etag := app.NewImage([]byte("success result"), modTime).ETag
+	config := DefaultConfig()
+	config.CacheMaxAge = time.Hour
+
+	testData := []struct {
+		header http.Header
+		status int
+		body   string
+	}{
+		{http.Header{}, http.StatusOK, "success result"},
+		{http.Header{"If-None-Match": {etag}}, http.StatusNotModified, ""},
+		{http.Header{"If-None-Match": {`"other"`}}, http.StatusOK, "success result"},
+		{http.Header{"If-Modified-Since": {modTime.Format(http.TimeFormat)}}, http.StatusNotModified, ""},
+		{
+			http.Header{"If-Modified-Since": {modTime.Add(-time.Hour).Format(http.TimeFormat)}},
+			http.StatusOK, "success result",
+		},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			img := app.NewImage([]byte("success result"), modTime)
+			rq := httptest.NewRequest(http.MethodGet, "http://x/1/1/x", nil)
+			rq.Header = td.header
+
+			appp := &mockapp.App{}
+			appp.
+				On("GetAndResize", rq.Context(), "http://x", newOptions(resizer.OperationFill, 1, 1), anyHeader).
+				Once().
+				Return(img, nil)
+
+			h := Handler{
+				app:    appp,
+				log:    &mocklogger.Logger{},
+				config: config,
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, rq)
+
+			rsp := w.Result()
+			defer rsp.Body.Close()
+			body, _ := io.ReadAll(rsp.Body)
+
+			require.Equal(t, td.status, rsp.StatusCode)
+			require.Equal(t, td.body, string(body))
+			require.Equal(t, img.ETag, rsp.Header.Get("ETag"))
+			require.Equal(t, "public, max-age=3600", rsp.Header.Get("Cache-Control"))
+
+			if td.status == http.StatusOK {
+				require.Equal(t, modTime.Format(http.TimeFormat), rsp.Header.Get("Last-Modified"))
+				require.Equal(t, "image/jpeg", rsp.Header.Get("Content-Type"))
+				require.Equal(t, strconv.Itoa(len("success result")), rsp.Header.Get("Content-Length"))
+			}
+		})
+	}
+}
+
+// bodyStub is a streamed body recording its closing.
+type bodyStub struct {
+	*strings.Reader
+	closed bool
+}
+
+func (b *bodyStub) Close() error {
+	b.closed = true
+
+	return nil
+}
+
+func TestHandler_ServeHTTP_Stream(t *testing.T) {
+	t.Parallel()
+
+	testData := []struct {
+		rangeHeader string
+		status      int
+		body        string
+		header      http.Header
+	}{
+		{"", http.StatusOK, "0123456789", http.Header{"Content-Length": {"10"}, "Accept-Ranges": {"bytes"}}},
+		{"bytes=2-5", http.StatusPartialContent, "2345", http.Header{"Content-Range": {"bytes 2-5/10"}}},
+		{"bytes=-3", http.StatusPartialContent, "789", http.Header{"Content-Range": {"bytes 7-9/10"}}},
+		{"bytes=20-", http.StatusRequestedRangeNotSatisfiable, "", http.Header{"Content-Range": {"bytes */10"}}},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			body := &bodyStub{Reader: strings.NewReader("0123456789")}
+			img := &app.Image{Body: body, ETag: `"etag"`, ModTime: time.Now()}
+
+			rq := httptest.NewRequest(http.MethodGet, "http://x/1/1/x", nil)
+			if td.rangeHeader != "" {
+				rq.Header.Set("Range", td.rangeHeader)
+			}
+
+			appp := &mockapp.App{}
+			appp.
+				On("GetAndResize", rq.Context(), "http://x", newOptions(resizer.OperationFill, 1, 1), anyHeader).
+				Once().
+				Return(img, nil)
+
+			h := Handler{
+				app:    appp,
+				log:    &mocklogger.Logger{},
+				config: DefaultConfig(),
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, rq)
+
+			rsp := w.Result()
+			defer rsp.Body.Close()
+			actual, _ := io.ReadAll(rsp.Body)
+
+			require.Equal(t, td.status, rsp.StatusCode)
+			if td.status != http.StatusRequestedRangeNotSatisfiable {
+				require.Equal(t, td.body, string(actual))
+			}
+			for key, values := range td.header {
+				require.Equal(t, values, rsp.Header.Values(key), key)
+			}
+			require.True(t, body.closed)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_ForwardedHeaders(t *testing.T) {
+	t.Parallel()
+
+	lastModified := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+
+	testData := []struct {
+		forwarded http.Header
+		expected  http.Header
+	}{
+		{
+			http.Header{
+				"Cache-Control": {"max-age=60"},
+				"Last-Modified": {lastModified},
+				"Etag":          {`"source"`},
+			},
+			http.Header{
+				"Cache-Control": {"max-age=60"},
+				"Last-Modified": {lastModified},
+			},
+		},
+		{
+			http.Header{"Expires": {expires}},
+			http.Header{"Expires": {expires}, "Cache-Control": nil},
+		},
+		{
+			http.Header{"Last-Modified": {"not a date"}},
+			http.Header{"Cache-Control": {"public, max-age=86400"}},
+		},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			img := app.NewImage([]byte("success result"), time.Now())
+			img.Header = td.forwarded
+
+			rq := httptest.NewRequest(http.MethodGet, "http://x/1/1/x", nil)
+
+			appp := &mockapp.App{}
+			appp.
+				On("GetAndResize", rq.Context(), "http://x", newOptions(resizer.OperationFill, 1, 1), anyHeader).
+				Once().
+				Return(img, nil)
+
+			h := Handler{
+				app:    appp,
+				log:    &mocklogger.Logger{},
+				config: DefaultConfig(),
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, rq)
+
+			rsp := w.Result()
+			defer rsp.Body.Close()
+
+			require.Equal(t, http.StatusOK, rsp.StatusCode)
+			require.Equal(t, img.ETag, rsp.Header.Get("ETag"))
+			for key, values := range td.expected {
+				require.Equal(t, values, rsp.Header.Values(key), key)
+			}
+		})
+	}
+}
+
 func TestHandler_ServeHTTP_AppError(t *testing.T) {
+	t.Parallel()
+
+	timeoutErr := &url.Error{Op: "Get", URL: "http://x", Err: &net.DNSError{IsTimeout: true}}
+
+	testData := []struct {
+		err     error
+		status  int
+		message string
+		header  http.Header
+	}{
+		{
+			errors.New("some app error"),
+			http.StatusInternalServerError, "Internal Server Error", http.Header{},
+		},
+		{
+			&app.UpstreamError{StatusCode: http.StatusNotFound, Header: http.Header{"Cache-Control": {"max-age=60"}}},
+			http.StatusNotFound, "source image: Not Found", http.Header{"Cache-Control": {"max-age=60"}},
+		},
+		{
+			fmt.Errorf("wrapped: %w", &app.UpstreamError{StatusCode: http.StatusForbidden}),
+			http.StatusForbidden, "source image: Forbidden", http.Header{},
+		},
+		{
+			&app.UpstreamError{StatusCode: http.StatusGone},
+			http.StatusGone, "source image: Gone", http.Header{},
+		},
+		{
+			&app.UpstreamError{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"10"}}},
+			http.StatusBadGateway, "upstream responded 503 Service Unavailable", http.Header{},
+		},
+		{
+			&app.UpstreamError{StatusCode: http.StatusUnauthorized},
+			http.StatusBadGateway, "upstream responded 401 Unauthorized", http.Header{},
+		},
+		{
+			&app.UpstreamError{Err: errors.New("connection refused")},
+			http.StatusBadGateway, "source server is unavailable", http.Header{},
+		},
+		{
+			&app.UpstreamError{Err: context.DeadlineExceeded},
+			http.StatusGatewayTimeout, "source server timed out", http.Header{},
+		},
+		{
+			&app.UpstreamError{Err: timeoutErr},
+			http.StatusGatewayTimeout, "source server timed out", http.Header{},
+		},
+		{
+			fmt.Errorf("resize: %w", &resizer.DecodeError{Err: errors.New("unknown format")}),
+			http.StatusUnsupportedMediaType, "source is not an image of a supported format", http.Header{},
+		},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			rq := httptest.NewRequest(http.MethodGet, "http://x/10/11/www.example.com/image.jpg", nil)
+			w := httptest.NewRecorder()
+
+			logg := &mocklogger.Logger{}
+			logg.On("Warn", mock.MatchedBy(func(msg string) bool {
+				return strings.Contains(msg, td.err.Error())
+			}))
+
+			appp := &mockapp.App{}
+			appp.
+				On(
+					"GetAndResize",
+					rq.Context(),
+					"http://www.example.com/image.jpg",
+					newOptions(resizer.OperationFill, 10, 11),
+					anyHeader,
+				).
+				Once().
+				Return(nil, td.err)
+
+			h := Handler{
+				app:    appp,
+				log:    logg,
+				config: DefaultConfig(),
+			}
+
+			h.ServeHTTP(w, rq)
+
+			rsp := w.Result()
+			defer rsp.Body.Close()
+			body, _ := io.ReadAll(rsp.Body)
+
+			require.Equal(t, td.status, rsp.StatusCode)
+			require.Equal(t, td.message, string(body))
+			require.Equal(t, "text/plain; charset=utf-8", rsp.Header.Get("Content-Type"))
+			require.Equal(t, td.header.Get("Cache-Control"), rsp.Header.Get("Cache-Control"))
+			require.Empty(t, rsp.Header.Get("Retry-After"))
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_JSONError(t *testing.T) {
 	rq := httptest.NewRequest(http.MethodGet, "http://x/10/11/www.example.com/image.jpg", nil)
+	rq.Header.Set("Accept", "application/json")
 	w := httptest.NewRecorder()
-	testError := errors.New("some app error")
 
 	logg := &mocklogger.Logger{}
-	logg.On("Warn", mock.MatchedBy(func(msg string) bool {
-		return strings.Contains(msg, testError.Error())
-	}))
+	logg.On("Warn", mock.Anything)
 
-	app := &mockapp.App{}
-	app.
-		On("GetAndResize", rq.Context(), "http://www.example.com/image.jpg", 10, 11, rq.Header).
+	appp := &mockapp.App{}
+	appp.
+		On("GetAndResize", rq.Context(), "http://www.example.com/image.jpg", mock.Anything, anyHeader).
 		Once().
-		Return(nil, testError)
+		Return(nil, &app.UpstreamError{StatusCode: http.StatusNotFound})
 
 	h := Handler{
-		app: app,
-		log: logg,
+		app:    appp,
+		log:    logg,
+		config: DefaultConfig(),
 	}
 
 	h.ServeHTTP(w, rq)
 
 	rsp := w.Result()
-	body, _ := io.ReadAll(rsp.Body)
+	defer rsp.Body.Close()
 
-	require.Equal(t, http.StatusBadGateway, rsp.StatusCode)
-	require.Equal(t, string(body), badRequestText)
+	var body errorBody
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&body))
 
-	rsp.Body.Close()
+	require.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	require.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	require.Equal(t, errorBody{http.StatusNotFound, "source image: Not Found"}, body)
 }
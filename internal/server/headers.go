@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders are the connection specific headers of RFC 7230 which are never forwarded.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// transportHeaders describe the request to the service itself, the client sets its own ones upstream.
+var transportHeaders = []string{
+	"Host",
+	"Accept-Encoding",
+	"Content-Length",
+}
+
+// conditionalHeaders validate and select the parts of the preview, the service answers them itself. Sent to the
+// source they make it respond 304 or 206 instead of the image.
+var conditionalHeaders = []string{
+	"If-Match",
+	"If-Modified-Since",
+	"If-None-Match",
+	"If-Range",
+	"If-Unmodified-Since",
+	"Range",
+}
+
+// DefaultDenyHeaders are the credentials of the service clients which must not leak to the source servers.
+var DefaultDenyHeaders = []string{"Authorization", "Cookie"}
+
+// upstreamHeaders builds the headers of the request to the source server. The hop-by-hop, transport and conditional
+// headers are stripped, the rest is filtered by the allow list when it is set and by the deny list, then the client
+// address is appended to X-Forwarded-For and Forwarded.
+func (h *Handler) upstreamHeaders(r *http.Request) http.Header {
+	header := r.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	for _, connection := range header.Values("Connection") {
+		for _, key := range strings.Split(connection, ",") {
+			header.Del(strings.TrimSpace(key))
+		}
+	}
+
+	deleteHeaders(header, hopByHopHeaders)
+	deleteHeaders(header, transportHeaders)
+	deleteHeaders(header, conditionalHeaders)
+	deleteHeaders(header, h.config.DenyHeaders)
+
+	if len(h.config.AllowHeaders) > 0 {
+		allowed := make(map[string]bool, len(h.config.AllowHeaders))
+		for _, key := range h.config.AllowHeaders {
+			allowed[http.CanonicalHeaderKey(key)] = true
+		}
+
+		for key := range header {
+			if !allowed[key] {
+				delete(header, key)
+			}
+		}
+	}
+
+	appendForwarded(header, r)
+
+	return header
+}
+
+func deleteHeaders(header http.Header, keys []string) {
+	for _, key := range keys {
+		header.Del(key)
+	}
+}
+
+func appendForwarded(header http.Header, r *http.Request) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if ip == "" {
+		return
+	}
+
+	appendHeader(header, "X-Forwarded-For", ip)
+
+	node := ip
+	if strings.Contains(ip, ":") {
+		node = `"[` + ip + `]"`
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	appendHeader(header, "Forwarded", "for="+node+";proto="+proto)
+}
+
+// appendHeader joins the value to the list the header already holds.
+func appendHeader(header http.Header, key, value string) {
+	if prior := header.Values(key); len(prior) > 0 {
+		value = strings.Join(prior, ", ") + ", " + value
+	}
+
+	header.Set(key, value)
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pustato/image-previewer/internal/app"
+	"github.com/pustato/image-previewer/internal/client"
+	mocklogger "github.com/pustato/image-previewer/internal/logger/mocks"
+	mockresizer "github.com/pustato/image-previewer/internal/resizer/mocks"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_upstreamHeaders(t *testing.T) {
+	t.Parallel()
+
+	testData := []struct {
+		config     func(c *Config)
+		remoteAddr string
+		in         http.Header
+		expected   http.Header
+	}{
+		{
+			func(c *Config) {},
+			"192.0.2.1:1234",
+			http.Header{
+				"Connection":          {"keep-alive, X-Session"},
+				"Keep-Alive":          {"timeout=5"},
+				"X-Session":           {"123"},
+				"Te":                  {"trailers"},
+				"Upgrade":             {"websocket"},
+				"Proxy-Authorization": {"Basic secret"},
+				"Transfer-Encoding":   {"chunked"},
+				"Accept-Encoding":     {"gzip"},
+				"Authorization":       {"Bearer secret"},
+				"Cookie":              {"session=secret"},
+				"X-Access":            {"secret"},
+				"Accept-Language":     {"ru", "en"},
+			},
+			http.Header{
+				"X-Access":        {"secret"},
+				"Accept-Language": {"ru", "en"},
+				"X-Forwarded-For": {"192.0.2.1"},
+				"Forwarded":       {"for=192.0.2.1;proto=http"},
+			},
+		},
+		{
+			func(c *Config) {},
+			"[2001:db8::1]:1234",
+			http.Header{
+				"X-Forwarded-For": {"198.51.100.1, 198.51.100.2"},
+				"Forwarded":       {"for=198.51.100.1"},
+			},
+			http.Header{
+				"X-Forwarded-For": {"198.51.100.1, 198.51.100.2, 2001:db8::1"},
+				"Forwarded":       {`for=198.51.100.1, for="[2001:db8::1]";proto=http`},
+			},
+		},
+		{
+			func(c *Config) {
+				c.AllowHeaders = []string{"x-access", "authorization"}
+			},
+			"192.0.2.1:1234",
+			http.Header{
+				"Authorization": {"Bearer secret"},
+				"X-Access":      {"secret"},
+				"X-Other":       {"value"},
+			},
+			http.Header{
+				"X-Access":        {"secret"},
+				"X-Forwarded-For": {"192.0.2.1"},
+				"Forwarded":       {"for=192.0.2.1;proto=http"},
+			},
+		},
+		{
+			func(c *Config) {
+				c.AllowHeaders = []string{"range", "if-none-match", "x-access"}
+			},
+			"192.0.2.1:1234",
+			http.Header{
+				"Range":               {"bytes=0-9"},
+				"If-Range":            {`"etag"`},
+				"If-None-Match":       {`"etag"`},
+				"If-Match":            {`"etag"`},
+				"If-Modified-Since":   {"Fri, 01 Jan 2021 00:00:00 GMT"},
+				"If-Unmodified-Since": {"Fri, 01 Jan 2021 00:00:00 GMT"},
+				"X-Access":            {"secret"},
+			},
+			http.Header{
+				"X-Access":        {"secret"},
+				"X-Forwarded-For": {"192.0.2.1"},
+				"Forwarded":       {"for=192.0.2.1;proto=http"},
+			},
+		},
+		{
+			func(c *Config) {
+				c.DenyHeaders = []string{"X-Forwarded-For", "forwarded", "x-access"}
+			},
+			"192.0.2.1:1234",
+			http.Header{
+				"X-Forwarded-For": {"198.51.100.1"},
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Access":        {"secret"},
+				"Cookie":          {"session=secret"},
+			},
+			http.Header{
+				"X-Forwarded-For": {"192.0.2.1"},
+				"Forwarded":       {"for=192.0.2.1;proto=http"},
+				"Cookie":          {"session=secret"},
+			},
+		},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			config := DefaultConfig()
+			td.config(&config)
+			h := Handler{config: config}
+
+			rq := httptest.NewRequest(http.MethodGet, "http://x/1/1/x", nil)
+			rq.RemoteAddr = td.remoteAddr
+			rq.Header = td.in
+
+			require.Equal(t, td.expected, h.upstreamHeaders(rq))
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_NoSensitiveHeadersLeak(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received http.Header
+	)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = r.Header.Clone()
+		mu.Unlock()
+
+		_, _ = w.Write([]byte("image"))
+	}))
+	defer upstream.Close()
+
+	resizer := &mockresizer.Resizer{}
+	resizer.
+		On("Resize", mock.Anything, mock.Anything).
+		Once().
+		Return([]byte("preview"), nil)
+
+	h := Handler{
+		app:    app.NewResizerApp(client.NewHTTPClient(time.Second), resizer),
+		log:    &mocklogger.Logger{},
+		config: DefaultConfig(),
+	}
+
+	rq := httptest.NewRequest(http.MethodGet, "http://x/1/1/"+upstream.Listener.Addr().String()+"/image.jpg", nil)
+	rq.Header.Set("Authorization", "Bearer secret")
+	rq.Header.Set("Cookie", "session=secret")
+	rq.Header.Set("Proxy-Authorization", "Basic secret")
+	rq.Header.Set("Connection", "X-Token")
+	rq.Header.Set("X-Token", "secret")
+	rq.Header.Set("X-Access", "secret")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, rq)
+
+	rsp := w.Result()
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, key := range []string{"Authorization", "Cookie", "Proxy-Authorization", "X-Token"} {
+		require.Empty(t, received.Values(key), key)
+	}
+	require.Equal(t, "secret", received.Get("X-Access"))
+	require.Equal(t, "192.0.2.1", received.Get("X-Forwarded-For"))
+}
+
+func TestHandler_ServeHTTP_ConditionalRequest(t *testing.T) {
+	t.Parallel()
+
+	lastModified := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"source"`)
+		http.ServeContent(w, r, "", lastModified, strings.NewReader("image"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	testData := []struct {
+		header http.Header
+		status int
+		body   string
+	}{
+		{http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}}, http.StatusNotModified, ""},
+		{http.Header{"If-None-Match": {`"source"`}}, http.StatusOK, "preview"},
+		{http.Header{"Range": {"bytes=0-2"}}, http.StatusPartialContent, "pre"},
+		{http.Header{"Range": {"bytes=0-2"}, "If-Range": {`"source"`}}, http.StatusOK, "preview"},
+		{
+			http.Header{"If-Unmodified-Since": {lastModified.Add(-time.Hour).Format(http.TimeFormat)}},
+			http.StatusPreconditionFailed,
+			"",
+		},
+	}
+
+	for i, td := range testData {
+		td := td
+		t.Run(fmt.Sprintf("test case %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			resizer := &mockresizer.Resizer{}
+			resizer.
+				On("Resize", mock.Anything, mock.Anything).
+				Once().
+				Return([]byte("preview"), nil)
+
+			h := Handler{
+				app:    app.NewResizerApp(client.NewHTTPClient(time.Second), resizer),
+				log:    &mocklogger.Logger{},
+				config: DefaultConfig(),
+			}
+
+			rq := httptest.NewRequest(http.MethodGet, "http://x/1/1/"+upstream.Listener.Addr().String()+"/image.jpg", nil)
+			for key, values := range td.header {
+				rq.Header[key] = values
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, rq)
+
+			rsp := w.Result()
+			defer rsp.Body.Close()
+			actual, _ := io.ReadAll(rsp.Body)
+
+			require.Equal(t, td.status, rsp.StatusCode)
+			require.Equal(t, td.body, string(actual))
+			resizer.AssertExpectations(t)
+		})
+	}
+}
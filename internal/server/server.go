@@ -5,20 +5,71 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/pustato/image-previewer/internal/app"
 	"github.com/pustato/image-previewer/internal/logger"
+	"github.com/pustato/image-previewer/internal/resizer"
 )
 
+type Config struct {
+	// DefaultQuality is used for the requests without the q option.
+	DefaultQuality int
+	// MinQuality and MaxQuality clamp the q option of the requests.
+	MinQuality, MaxQuality int
+	// CacheMaxAge is the max-age of the Cache-Control header of the previews.
+	CacheMaxAge time.Duration
+	// AllowHeaders limits the request headers forwarded to the source servers, empty means any.
+	AllowHeaders []string
+	// DenyHeaders are the request headers never forwarded to the source servers.
+	DenyHeaders []string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		DefaultQuality: resizer.DefaultQuality,
+		MinQuality:     1,
+		MaxQuality:     100,
+		CacheMaxAge:    24 * time.Hour,
+		DenyHeaders:    DefaultDenyHeaders,
+	}
+}
+
+func (c Config) Validate() error {
+	if c.MinQuality < minQuality || c.MaxQuality > maxQuality || c.MinQuality > c.MaxQuality {
+		return fmt.Errorf("quality range %d-%d: %w", c.MinQuality, c.MaxQuality, ErrInvalidQuality)
+	}
+
+	if c.DefaultQuality < c.MinQuality || c.DefaultQuality > c.MaxQuality {
+		return fmt.Errorf("default quality %d: %w", c.DefaultQuality, ErrInvalidQuality)
+	}
+
+	if c.CacheMaxAge < 0 {
+		return fmt.Errorf("cache max age %s: %w", c.CacheMaxAge, ErrNegativeMaxAge)
+	}
+
+	return nil
+}
+
 type Server struct {
 	server *http.Server
 }
 
-func NewServer(addr string, app app.App, logg logger.Logger) *Server {
+func NewServer(addr string, app app.App, logg logger.Logger, config Config) *Server {
+	return &Server{
+		server: &http.Server{
+			Addr:    addr,
+			Handler: &Handler{app, logg, config},
+		},
+	}
+}
+
+// NewAdminServer serves the cache listing and stats.
+func NewAdminServer(addr string, cache CacheInspector, logg logger.Logger) *Server {
 	return &Server{
 		server: &http.Server{
 			Addr:    addr,
-			Handler: &Handler{app, logg},
+			Handler: &AdminHandler{cache, logg},
 		},
 	}
 }
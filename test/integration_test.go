@@ -7,45 +7,53 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/require"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 )
 
+// dirSize sums the sizes of the previews and their metadata in the sharded cache directory.
 func dirSize(t *testing.T, dir string) (size uint64) {
 	t.Helper()
 
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		t.Error(err.Error())
-	}
-
-	for _, file := range files {
-		if file.IsDir() {
-			continue
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
 		}
 
-		info, err := file.Info()
+		info, err := entry.Info()
 		if err != nil {
-			t.Error(err.Error())
+			return err
 		}
+		size += uint64(info.Size())
 
-		if info.Mode().IsRegular() {
-			size += uint64(info.Size())
-		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err.Error())
 	}
 
 	return
 }
 
 func buildUrl(c *Config, w, h int, path string) string {
+	return buildOpUrl(c, "", w, h, path)
+}
+
+func buildOpUrl(c *Config, op string, w, h int, path string) string {
 	b := strings.Builder{}
 	b.WriteString("http://")
 	b.WriteString(c.serviceAddr)
 	b.WriteString("/")
+	if op != "" {
+		b.WriteString(op)
+		b.WriteString("/")
+	}
 	b.WriteString(strconv.Itoa(w))
 	b.WriteString("/")
 	b.WriteString(strconv.Itoa(h))
@@ -76,6 +84,9 @@ func TestSimple(t *testing.T) {
 
 			{buildUrl(config, 100, 1000, url.PathEscape("/file?name=gopher.jpg")), "testdata/gopher_100_1000.jpg"},
 			{buildUrl(config, 2000, 1000, url.PathEscape("/file?name=gopher.jpg")), "testdata/gopher_2000_1000.jpg"},
+
+			{buildOpUrl(config, "fill", 100, 50, "/gopher.jpg"), "testdata/gopher_100_50.jpg"},
+			{buildOpUrl(config, "fill", 1024, 504, "/gopher.jpg"), "testdata/gopher_1024_504.jpg"},
 		}
 
 		for i, td := range testData {
@@ -110,11 +121,13 @@ func TestSimple(t *testing.T) {
 			{"http://" + config.serviceAddr + "/", http.StatusNotFound, "malformed request path"},
 			{"http://" + config.serviceAddr + "/1", http.StatusNotFound, "malformed request path"},
 			{"http://" + config.serviceAddr + "/1/1", http.StatusNotFound, "malformed request path"},
-			{"http://" + config.serviceAddr + "/1/1/x", http.StatusBadGateway, "bad request"},
+			{"http://" + config.serviceAddr + "/1/1/x", http.StatusBadGateway, "source server is unavailable"},
 			{"http://" + config.serviceAddr + "/x/1/x", http.StatusNotFound, "width is not a number"},
 			{"http://" + config.serviceAddr + "/1/x/x", http.StatusNotFound, "height is not a number"},
 			{"http://" + config.serviceAddr + "/x/x/x", http.StatusNotFound, "width is not a number"},
-			{buildUrl(config, 2000, 1000, "/file?name=gopher.jpg"), http.StatusBadGateway, "bad request"},
+			{buildUrl(config, 2000, 1000, "/file?name=gopher.jpg"), http.StatusNotFound, "source image: Not Found"},
+			{buildUrl(config, 100, 100, "/not_exists.jpg"), http.StatusNotFound, "source image: Not Found"},
+			{buildUrl(config, 100, 100, "/script.sh"), http.StatusUnsupportedMediaType, "not an image"},
 		}
 
 		for i, td := range testData {
@@ -142,9 +155,9 @@ func TestProxyHeaders(t *testing.T) {
 	protectedUrl := buildUrl(config, 2000, 1000, "/protected/gopher.jpg")
 
 	rsp, err := client.Get(protectedUrl)
-	defer rsp.Body.Close()
 	require.NoError(t, err)
-	require.Equal(t, http.StatusBadGateway, rsp.StatusCode)
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
 
 	rq, err := http.NewRequest(http.MethodGet, protectedUrl, nil)
 	require.NoError(t, err)
@@ -180,4 +193,6 @@ func TestCacheRotation(t *testing.T) {
 		cacheDirSize := dirSize(t, config.cacheDir)
 		require.LessOrEqual(t, cacheDirSize, config.cacheSize)
 	}
+
+	require.NotZero(t, dirSize(t, config.cacheDir))
 }